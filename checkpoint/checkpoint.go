@@ -0,0 +1,42 @@
+// Records migration progress so an interrupted run can be resumed
+package checkpoint
+
+import (
+	"context"
+	"pouncecat/helpers"
+	"time"
+)
+
+// Progress of a single table
+type State struct {
+	// Whether the table has been fully migrated
+	Done bool `json:"done"`
+	// Encoded source key of the last record written, for resuming partially migrated tables
+	LastKey string `json:"last_key,omitempty"`
+	// Number of rows written so far
+	Rows int64 `json:"rows"`
+	// Number of source records that were skipped or whose insert errors were ignored, -1 if unknown as the last
+	// incremental sync only saw changed records
+	Rejected int64 `json:"rejected"`
+	// Number of stub rows inserted into the table for the foreign keys of other tables' rows
+	Stubs int64 `json:"stubs"`
+	// When the last successful migration or sync of the table started
+	SyncedAt time.Time `json:"synced_at"`
+}
+
+type Store interface {
+	// Returns the state of a table, the zero State if there is none
+	Get(ctx context.Context, table string) (State, error)
+	// Saves the state of a table
+	Set(ctx context.Context, table string, state State) error
+	// Clears all state, called when a fresh (non-resumed) run starts
+	Reset(ctx context.Context) error
+}
+
+// Implemented by stores keeping checkpoints in the destination database, so a checkpoint can be committed in the same
+// transaction as the rows it covers
+type TxStore interface {
+	Store
+	// Returns a Store reading and writing through tx, which must be a transaction on the same database
+	InTx(tx helpers.Querier) Store
+}
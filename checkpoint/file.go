@@ -0,0 +1,65 @@
+package checkpoint
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"pouncecat/helpers"
+	"sync"
+)
+
+// Stores checkpoints in a local JSON file
+type FileStore struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (f *FileStore) Get(ctx context.Context, table string) (State, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	states, err := helpers.ReadJSONMap[State](f.Path)
+
+	if err != nil {
+		return State{}, err
+	}
+
+	return states[table], nil
+}
+
+func (f *FileStore) Set(ctx context.Context, table string, state State) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	states, err := helpers.ReadJSONMap[State](f.Path)
+
+	if err != nil {
+		return err
+	}
+
+	states[table] = state
+
+	bytes, err := json.MarshalIndent(states, "", "\t")
+
+	if err != nil {
+		return err
+	}
+
+	return helpers.WriteFileAtomic(f.Path, bytes)
+}
+
+func (f *FileStore) Reset(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := os.Remove(f.Path)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
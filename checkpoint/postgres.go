@@ -0,0 +1,114 @@
+package checkpoint
+
+import (
+	"context"
+	"pouncecat/helpers"
+	"sync"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Stores checkpoints in a pouncecat_state table
+type PostgresStore struct {
+	Pool *pgxpool.Pool
+	// Schema of the pouncecat_state table, defaults to pouncecat. Should not be a schema being migrated into, as
+	// recreating or swapping that would lose the checkpoints
+	Schema string
+
+	mu      sync.Mutex
+	ensured bool
+	// Set on the stores returned by InTx
+	tx     helpers.Querier
+	parent *PostgresStore
+}
+
+func (p *PostgresStore) InTx(tx helpers.Querier) Store {
+	return &PostgresStore{Pool: p.Pool, Schema: p.Schema, tx: tx, parent: p}
+}
+
+func (p *PostgresStore) db() helpers.Querier {
+	if p.tx != nil {
+		return p.tx
+	}
+
+	return p.Pool
+}
+
+func (p *PostgresStore) schema() string {
+	if p.Schema == "" {
+		return "pouncecat"
+	}
+
+	return p.Schema
+}
+
+func (p *PostgresStore) tableName() string {
+	return helpers.QuoteIdent(p.schema(), "pouncecat_state")
+}
+
+// Creates the table on first use, outside of any transaction
+func (p *PostgresStore) ensure(ctx context.Context) error {
+	if p.parent != nil {
+		return p.parent.ensure(ctx)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.ensured {
+		return nil
+	}
+
+	_, err := p.Pool.Exec(ctx, "CREATE SCHEMA IF NOT EXISTS "+helpers.QuoteIdent(p.schema()))
+
+	if err != nil {
+		return err
+	}
+
+	_, err = p.Pool.Exec(ctx, "CREATE TABLE IF NOT EXISTS "+p.tableName()+" (table_name TEXT PRIMARY KEY, done BOOLEAN NOT NULL DEFAULT false, last_key TEXT NOT NULL DEFAULT '', row_count BIGINT NOT NULL DEFAULT 0, rejected_count BIGINT NOT NULL DEFAULT 0, stub_count BIGINT NOT NULL DEFAULT 0, synced_at TIMESTAMPTZ NOT NULL DEFAULT 'epoch')")
+
+	if err != nil {
+		return err
+	}
+
+	p.ensured = true
+
+	return nil
+}
+
+func (p *PostgresStore) Get(ctx context.Context, table string) (State, error) {
+	var state State
+
+	if err := p.ensure(ctx); err != nil {
+		return state, err
+	}
+
+	err := p.db().QueryRow(ctx, "SELECT done, last_key, row_count, synced_at, rejected_count, stub_count FROM "+p.tableName()+" WHERE table_name = $1", table).Scan(&state.Done, &state.LastKey, &state.Rows, &state.SyncedAt, &state.Rejected, &state.Stubs)
+
+	if err == pgx.ErrNoRows {
+		return State{}, nil
+	}
+
+	return state, err
+}
+
+func (p *PostgresStore) Set(ctx context.Context, table string, state State) error {
+	if err := p.ensure(ctx); err != nil {
+		return err
+	}
+
+	_, err := p.db().Exec(ctx, "INSERT INTO "+p.tableName()+" (table_name, done, last_key, row_count, synced_at, rejected_count, stub_count) VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT (table_name) DO UPDATE SET done = EXCLUDED.done, last_key = EXCLUDED.last_key, row_count = EXCLUDED.row_count, synced_at = EXCLUDED.synced_at, rejected_count = EXCLUDED.rejected_count, stub_count = EXCLUDED.stub_count", table, state.Done, state.LastKey, state.Rows, state.SyncedAt, state.Rejected, state.Stubs)
+
+	return err
+}
+
+func (p *PostgresStore) Reset(ctx context.Context) error {
+	if err := p.ensure(ctx); err != nil {
+		return err
+	}
+
+	_, err := p.db().Exec(ctx, "DELETE FROM "+p.tableName())
+
+	return err
+}
@@ -1,7 +1,12 @@
 package column
 
 import (
+	"context"
 	"fmt"
+	"pouncecat/helpers"
+	"pouncecat/source"
+	"pouncecat/ui"
+	"sort"
 	"time"
 )
 
@@ -22,6 +27,45 @@ const ArrayJSONDefault = "{}"
 
 type Transform func(record map[string]any, col any) any
 
+// A transform that also gets the context of the value being transformed
+type ContextTransform func(tc *TransformContext, record map[string]any, col any) any
+
+// Describes where a value being transformed comes from
+type TransformContext struct {
+	// Cancelled when the run is interrupted, transforms doing slow work should give up once it is done
+	Ctx    context.Context
+	Table  string
+	Column *Column
+	// Index of the record in the records fetched for the table, starting at 1. 0 when syncing or verifying
+	Row    int
+	Source source.Source
+	// Destination database. Transforms run before the DDL and transaction of the table, so it can query other tables freely
+	Dest helpers.Querier
+	// Changes source records, logged and honoring dry-run. Nil when the source is not writable or when only
+	// comparing (verifying), transforms must then leave the source alone
+	Writer source.WritableSource
+	// Logger with the table and column set
+	Log *ui.Logger
+}
+
+// Adapts plain transforms so they can run alongside context transforms
+func ignoreContext(transforms []Transform) []ContextTransform {
+	wrapped := make([]ContextTransform, 0, len(transforms))
+
+	for _, fn := range transforms {
+		if fn == nil {
+			continue
+		}
+
+		fn := fn
+		wrapped = append(wrapped, func(tc *TransformContext, record map[string]any, col any) any {
+			return fn(record, col)
+		})
+	}
+
+	return wrapped
+}
+
 type Constraints struct {
 	Unique     bool
 	ForeignKey [2]string
@@ -39,23 +83,30 @@ func (c *Constraints) SetForeignKey(fk [2]string) *Constraints {
 
 type RawConstraint struct {
 	Type string
-	SQL  func(dstName string) string
+	// Returns the constraint definition, references are qualified with schema if set
+	SQL func(schema, dstName string) string
 }
 
 func (c *Constraints) Raw() []RawConstraint {
 	constraints := []RawConstraint{}
 
 	if c.Unique {
-		constraints = append(constraints, RawConstraint{Type: "unique", SQL: func(dstName string) string {
-			return "UNIQUE (" + dstName + ")"
+		constraints = append(constraints, RawConstraint{Type: "unique", SQL: func(schema, dstName string) string {
+			return "UNIQUE (" + helpers.QuoteIdent(dstName) + ")"
 		}})
 	}
 
 	if len(c.ForeignKey) > 0 && c.ForeignKey[0] != "" && c.ForeignKey[1] != "" {
 		constraints = append(constraints, RawConstraint{
 			Type: "fk",
-			SQL: func(dstName string) string {
-				return "FOREIGN KEY (" + dstName + ") REFERENCES " + c.ForeignKey[0] + "(" + c.ForeignKey[1] + ") ON DELETE CASCADE ON UPDATE CASCADE"
+			SQL: func(schema, dstName string) string {
+				ref := helpers.QuoteIdent(c.ForeignKey[0])
+
+				if schema != "" {
+					ref = helpers.QuoteIdent(schema, c.ForeignKey[0])
+				}
+
+				return "FOREIGN KEY (" + helpers.QuoteIdent(dstName) + ") REFERENCES " + ref + "(" + helpers.QuoteIdent(c.ForeignKey[1]) + ") ON UPDATE CASCADE ON DELETE CASCADE"
 			},
 		})
 	}
@@ -63,6 +114,38 @@ func (c *Constraints) Raw() []RawConstraint {
 	return constraints
 }
 
+// Computes a value of a stub parent row from the missing key, such as a username looked up through an enricher
+type StubValue func(ctx context.Context, key any) any
+
+// Template of the stub row inserted into the parent table when a foreign key references a parent that does not exist
+type ParentStub struct {
+	// Values of the stub row by column name, besides the referenced key. StubValue values are called with the key
+	Values map[string]any
+}
+
+// Returns the value of each stub column for the key, in the order of cols
+func (p *ParentStub) Row(ctx context.Context, key any) (cols []string, values []any) {
+	for col := range p.Values {
+		cols = append(cols, col)
+	}
+
+	sort.Strings(cols)
+
+	for _, col := range cols {
+		v := p.Values[col]
+
+		if fn, ok := v.(StubValue); ok {
+			v = fn(ctx, key)
+		} else if fn, ok := v.(func(ctx context.Context, key any) any); ok {
+			v = fn(ctx, key)
+		}
+
+		values = append(values, v)
+	}
+
+	return cols, values
+}
+
 type Column struct {
 	// The underlying type of the column.
 	Type ColumnType
@@ -80,8 +163,12 @@ type Column struct {
 	Default any
 	// SQL default value of the column.
 	SQLDefault string
-	// Any transformations for the column.
-	Transforms []Transform
+	// Any transformations for the column, run in order.
+	Transforms []ContextTransform
+	// Whether the value is not reproducible (random, network or prompt based), such columns are not compared on verify
+	Volatile bool
+	// Stub to insert into the parent table when the foreign key references a missing parent, rows fail on the foreign key if nil
+	EnsureParent *ParentStub
 }
 
 func (c *Column) GetDefault() string {
@@ -92,9 +179,9 @@ func (c *Column) GetDefault() string {
 	if c.Default != nil {
 		switch casted := c.Default.(type) {
 		case string:
-			return fmt.Sprintf("'%v'", casted)
+			return helpers.QuoteLiteral(casted)
 		case time.Time:
-			return fmt.Sprintf("'%v'", casted.Format(time.RFC3339))
+			return helpers.QuoteLiteral(casted.Format(time.RFC3339))
 		default:
 			return fmt.Sprintf("%v", casted)
 		}
@@ -107,6 +194,35 @@ func (c *Column) GetDefault() string {
 	return ""
 }
 
+// Checks that the column and its constraints only reference safe identifiers
+func (c *Column) Validate() error {
+	if err := helpers.ValidateIdent(c.DstName); err != nil {
+		return fmt.Errorf("column %s: %w", c.SrcName, err)
+	}
+
+	if c.Constraints != nil && (c.Constraints.ForeignKey[0] != "" || c.Constraints.ForeignKey[1] != "") {
+		for _, name := range c.Constraints.ForeignKey {
+			if err := helpers.ValidateIdent(name); err != nil {
+				return fmt.Errorf("column %s: foreign key: %w", c.DstName, err)
+			}
+		}
+	}
+
+	if c.EnsureParent != nil {
+		if c.Constraints == nil || c.Constraints.ForeignKey[0] == "" {
+			return fmt.Errorf("column %s: ensure parent needs a foreign key", c.DstName)
+		}
+
+		for name := range c.EnsureParent.Values {
+			if err := helpers.ValidateIdent(name); err != nil {
+				return fmt.Errorf("column %s: parent stub: %w", c.DstName, err)
+			}
+		}
+	}
+
+	return nil
+}
+
 func (c *Column) BaseType() string {
 	switch c.Type {
 	case ColumnTypeText:
@@ -142,17 +258,22 @@ func (c *Column) Meta() []string {
 		meta = append(meta, "NOT NULL")
 	}
 
-	if (c.Default != nil && c.Default != "SKIP") || c.SQLDefault != "" {
-		getDef := c.GetDefault()
-
-		if getDef != "" {
-			meta = append(meta, "DEFAULT "+getDef)
-		}
+	if getDef := c.DefaultSQL(); getDef != "" {
+		meta = append(meta, "DEFAULT "+getDef)
 	}
 
 	return meta
 }
 
+// Returns the SQL DEFAULT expression of the column, or an empty string if it has none
+func (c *Column) DefaultSQL() string {
+	if (c.Default != nil && c.Default != "SKIP") || c.SQLDefault != "" {
+		return c.GetDefault()
+	}
+
+	return ""
+}
+
 func (c *Column) SetArray(b bool) *Column {
 	c.Array = b
 	return c
@@ -178,6 +299,22 @@ func (c *Column) SetForeignKey(fk [2]string) *Column {
 	return c
 }
 
+func (c *Column) SetVolatile(b bool) *Column {
+	c.Volatile = b
+	return c
+}
+
+// Appends a transform that needs the context of the value, such as the source or destination handles
+func (c *Column) AddContextTransform(fn ContextTransform) *Column {
+	c.Transforms = append(c.Transforms, fn)
+	return c
+}
+
+func (c *Column) SetEnsureParent(stub *ParentStub) *Column {
+	c.EnsureParent = stub
+	return c
+}
+
 func (c *Column) SetSQLDefault(defValue string) *Column {
 	c.SQLDefault = defValue
 	return c
@@ -189,7 +326,7 @@ func NewText(srcName, dstName string, defValue any, transforms ...Transform) *Co
 		SrcName:     srcName,
 		DstName:     dstName,
 		Default:     defValue,
-		Transforms:  transforms,
+		Transforms:  ignoreContext(transforms),
 		Constraints: &Constraints{},
 	}
 }
@@ -200,7 +337,7 @@ func NewInt(srcName, dstName string, defValue any, transforms ...Transform) *Col
 		SrcName:     srcName,
 		DstName:     dstName,
 		Default:     defValue,
-		Transforms:  transforms,
+		Transforms:  ignoreContext(transforms),
 		Constraints: &Constraints{},
 	}
 }
@@ -211,7 +348,7 @@ func NewBigInt(srcName, dstName string, defValue any, transforms ...Transform) *
 		SrcName:     srcName,
 		DstName:     dstName,
 		Default:     defValue,
-		Transforms:  transforms,
+		Transforms:  ignoreContext(transforms),
 		Constraints: &Constraints{},
 	}
 }
@@ -222,7 +359,7 @@ func NewBool(srcName, dstName string, defValue bool, transforms ...Transform) *C
 		SrcName:     srcName,
 		DstName:     dstName,
 		Default:     defValue,
-		Transforms:  transforms,
+		Transforms:  ignoreContext(transforms),
 		Constraints: &Constraints{},
 	}
 }
@@ -233,7 +370,7 @@ func NewTimestamp(srcName, dstName string, defValue string, transforms ...Transf
 		SrcName:     srcName,
 		DstName:     dstName,
 		SQLDefault:  defValue,
-		Transforms:  transforms,
+		Transforms:  ignoreContext(transforms),
 		Constraints: &Constraints{},
 	}
 }
@@ -244,7 +381,7 @@ func NewJSONB(srcName, dstName string, transforms ...Transform) *Column {
 		SrcName:     srcName,
 		DstName:     dstName,
 		Default:     "{}",
-		Transforms:  transforms,
+		Transforms:  ignoreContext(transforms),
 		Constraints: &Constraints{},
 	}
 }
@@ -255,7 +392,7 @@ func NewUUID(srcName, dstName string, defValue string, transforms ...Transform)
 		SrcName:     srcName,
 		DstName:     dstName,
 		SQLDefault:  defValue,
-		Transforms:  transforms,
+		Transforms:  ignoreContext(transforms),
 		Constraints: &Constraints{},
 	}
 }
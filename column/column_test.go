@@ -0,0 +1,51 @@
+package column
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetDefault(t *testing.T) {
+	tests := []struct {
+		name string
+		col  *Column
+		want string
+	}{
+		{"string", NewText("bio", "bio", "I'm new"), "'I''m new'"},
+		{"empty string", NewText("bio", "bio", ""), "''"},
+		{"int", NewInt("votes", "votes", 0), "0"},
+		{"bool", NewBool("certified", "certified", false), "false"},
+		{"time", NewText("at", "at", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)), "'2020-01-01T00:00:00Z'"},
+		{"jsonb", NewJSONB("extra", "extra"), "'{}'"},
+		{"sql", NewTimestamp("created", "created_at", "NOW()"), "NOW()"},
+		{"none", NewText("bio", "bio", nil), ""},
+		{"no default", NewText("bio", "bio", NoDefault), ""},
+	}
+
+	for _, tt := range tests {
+		if got := tt.col.GetDefault(); got != tt.want {
+			t.Errorf("%s: GetDefault() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestColumnValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		col  *Column
+		ok   bool
+	}{
+		{"plain", NewText("botID", "bot_id", nil), true},
+		{"foreign key", NewText("owner", "owner", nil).SetForeignKey([2]string{"users", "user_id"}), true},
+		{"space", NewText("name", "Bad Name", nil), false},
+		{"injection", NewText("name", "a;drop", nil), false},
+		{"foreign key table", NewText("owner", "owner", nil).SetForeignKey([2]string{"users;drop", "user_id"}), false},
+		{"foreign key column", NewText("owner", "owner", nil).SetForeignKey([2]string{"users", "User ID"}), false},
+	}
+
+	for _, tt := range tests {
+		if err := tt.col.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package helpers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// Writes data to a temporary file next to path and renames it over path, so a crash never leaves a half-written file
+func WriteFileAtomic(path string, data []byte) error {
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// Reads a JSON object keyed by string from path, returning an empty map if the file does not exist
+func ReadJSONMap[V any](path string) (map[string]V, error) {
+	var m = map[string]V{}
+
+	bytes, err := os.ReadFile(path)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(bytes, &m)
+
+	return m, err
+}
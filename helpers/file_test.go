@@ -0,0 +1,44 @@
+package helpers
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestJSONMapRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	m, err := ReadJSONMap[int](path)
+
+	if err != nil || len(m) != 0 {
+		t.Fatalf("ReadJSONMap() of a missing file = %v, %v, want an empty map", m, err)
+	}
+
+	if err := WriteFileAtomic(path, []byte(`{"a":1,"b":2}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+
+	m, err = ReadJSONMap[int](path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := map[string]int{"a": 1, "b": 2}; !reflect.DeepEqual(m, want) {
+		t.Errorf("ReadJSONMap() = %v, want %v", m, want)
+	}
+
+	if err := WriteFileAtomic(path, []byte("not json")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ReadJSONMap[int](path); err == nil {
+		t.Error("ReadJSONMap() of invalid JSON did not fail")
+	}
+}
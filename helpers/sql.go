@@ -0,0 +1,66 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// Satisfied by both *pgxpool.Pool and pgx.Tx, so migrations can run with or without a transaction
+type Querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Postgres truncates identifiers longer than this
+const maxIdentLen = 63
+
+var identRegex = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// Quotes a SQL identifier (table, column, index name etc.)
+func QuoteIdent(parts ...string) string {
+	return pgx.Identifier(parts).Sanitize()
+}
+
+// Quotes a list of SQL identifiers, comma-separated
+func QuoteIdents(names []string) string {
+	quoted := make([]string, len(names))
+
+	for i, name := range names {
+		quoted[i] = QuoteIdent(name)
+	}
+
+	return strings.Join(quoted, ",")
+}
+
+// Quotes a string as a SQL literal, escaping any single quotes
+func QuoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(strings.ReplaceAll(s, "\x00", ""), "'", "''") + "'"
+}
+
+// Whether a string is a plain identifier (and not an expression such as lower(vanity))
+func IsIdent(name string) bool {
+	return identRegex.MatchString(name)
+}
+
+// Checks that a name is safe to use as a SQL identifier
+func ValidateIdent(name string) error {
+	if name == "" {
+		return errors.New("empty identifier")
+	}
+
+	if len(name) > maxIdentLen {
+		return errors.New("identifier " + name + " is longer than 63 bytes")
+	}
+
+	if !IsIdent(name) {
+		return errors.New("identifier " + name + " must only contain lowercase letters, digits and underscores")
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package helpers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQuoteLiteral(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"", "''"},
+		{"new", "'new'"},
+		{"I'm new", "'I''m new'"},
+		{"''", "''''''"},
+		{"a\x00b", "'ab'"},
+		{`back\slash`, `'back\slash'`},
+	}
+
+	for _, tt := range tests {
+		if got := QuoteLiteral(tt.s); got != tt.want {
+			t.Errorf("QuoteLiteral(%q) = %s, want %s", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestQuoteIdent(t *testing.T) {
+	if got, want := QuoteIdent("public", "bots"), `"public"."bots"`; got != want {
+		t.Errorf("QuoteIdent() = %s, want %s", got, want)
+	}
+
+	if got, want := QuoteIdents([]string{"bot_id", `we"ird`}), `"bot_id","we""ird"`; got != want {
+		t.Errorf("QuoteIdents() = %s, want %s", got, want)
+	}
+}
+
+func TestValidateIdent(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"bots", true},
+		{"bot_id", true},
+		{"_private", true},
+		{"v2", true},
+		{"", false},
+		{"Bad Name", false},
+		{"a;drop", false},
+		{"Bots", false},
+		{"2fa", false},
+		{"lower(vanity)", false},
+		{`"quoted"`, false},
+		{strings.Repeat("a", 63), true},
+		{strings.Repeat("a", 64), false},
+	}
+
+	for _, tt := range tests {
+		if err := ValidateIdent(tt.name); (err == nil) != tt.ok {
+			t.Errorf("ValidateIdent(%q) = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Package lookup lets transforms read records other than the one being migrated, from already migrated destination
+// tables or from in-memory snapshots of source entities
+package lookup
+
+import (
+	"context"
+	"fmt"
+	"pouncecat/helpers"
+	"pouncecat/source"
+	"pouncecat/ui"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Looks up records by key. Methods panic on errors, like transforms do
+type Lookup struct {
+	Source source.Source
+	Pool   *pgxpool.Pool
+	// Schema destination tables are read from, should be the target schema of the run (the staging schema in swap mode)
+	Schema string
+
+	mu        sync.Mutex
+	snapshots map[string][]map[string]any
+	indexes   map[string]*Index
+	rows      map[string]map[string]any
+}
+
+// Changes the schema destination tables are read from, such as after the staging schema is swapped in
+func (l *Lookup) SetSchema(schema string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.Schema = schema
+}
+
+func New(src source.Source, pool *pgxpool.Pool, schema string) *Lookup {
+	return &Lookup{
+		Source:    src,
+		Pool:      pool,
+		Schema:    schema,
+		snapshots: map[string][]map[string]any{},
+		indexes:   map[string]*Index{},
+		rows:      map[string]map[string]any{},
+	}
+}
+
+// Source records of an entity indexed by a field
+type Index struct {
+	fold    bool
+	records map[string][]map[string]any
+}
+
+func (i *Index) key(v any) string {
+	if i.fold {
+		return strings.ToLower(fmt.Sprint(v))
+	}
+
+	return fmt.Sprint(v)
+}
+
+// Returns the records whose field equals key
+func (i *Index) All(key any) []map[string]any {
+	return i.records[i.key(key)]
+}
+
+// Returns the first record whose field equals key, nil if there is none
+func (i *Index) Get(key any) map[string]any {
+	records := i.All(key)
+
+	if len(records) == 0 {
+		return nil
+	}
+
+	return records[0]
+}
+
+// Returns the index of entity by field, loading a snapshot of the entity from the source on first use.
+// If fold is set, string keys are compared case-insensitively. Records missing the field are not indexed
+func (l *Lookup) Index(ctx context.Context, entity, field string, fold bool) *Index {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	name := entity + "/" + field + "/" + fmt.Sprint(fold)
+
+	if idx, ok := l.indexes[name]; ok {
+		return idx
+	}
+
+	records, ok := l.snapshots[entity]
+
+	if !ok {
+		var err error
+		records, err = l.Source.GetRecords(ctx, entity)
+
+		if err != nil {
+			panic(fmt.Errorf("lookup: snapshot of %s: %w", entity, err))
+		}
+
+		ui.Log.Debug("Loaded lookup snapshot", ui.F("entity", entity), ui.F("records", len(records)))
+
+		l.snapshots[entity] = records
+	}
+
+	idx := &Index{fold: fold, records: map[string][]map[string]any{}}
+
+	for _, record := range records {
+		v, ok := record[field]
+
+		if !ok || v == nil {
+			continue
+		}
+
+		k := idx.key(v)
+		idx.records[k] = append(idx.records[k], record)
+	}
+
+	l.indexes[name] = idx
+
+	return idx
+}
+
+// Returns the source record of entity whose field equals key, nil if there is none
+func (l *Lookup) SourceRecord(ctx context.Context, entity, field string, key any) map[string]any {
+	return l.Index(ctx, entity, field, false).Get(key)
+}
+
+// Returns the row of a destination table whose column equals key, nil if there is none. Found rows are cached,
+// missing ones are not, as they may be inserted later in the run
+func (l *Lookup) Dest(ctx context.Context, table, col string, key any) map[string]any {
+	name := table + "/" + col + "/" + fmt.Sprint(key)
+
+	l.mu.Lock()
+	row, ok := l.rows[name]
+	schema := l.Schema
+	l.mu.Unlock()
+
+	if ok {
+		return row
+	}
+
+	rows, err := l.Pool.Query(ctx, "SELECT * FROM "+helpers.QuoteIdent(schema, table)+" WHERE "+helpers.QuoteIdent(col)+" = $1 LIMIT 1", key)
+
+	if err != nil {
+		panic(fmt.Errorf("lookup: %s: %w", table, err))
+	}
+
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			panic(fmt.Errorf("lookup: %s: %w", table, err))
+		}
+
+		return nil
+	}
+
+	values, err := rows.Values()
+
+	if err != nil {
+		panic(fmt.Errorf("lookup: %s: %w", table, err))
+	}
+
+	row = map[string]any{}
+
+	for i, fd := range rows.FieldDescriptions() {
+		row[string(fd.Name)] = values[i]
+	}
+
+	l.mu.Lock()
+	l.rows[name] = row
+	l.mu.Unlock()
+
+	return row
+}
+
+// Returns a single column of the destination row whose column equals key, nil if there is no such row
+func (l *Lookup) DestField(ctx context.Context, table, col string, key any, field string) any {
+	row := l.Dest(ctx, table, col, key)
+
+	if row == nil {
+		return nil
+	}
+
+	return row[field]
+}
@@ -5,22 +5,33 @@ import (
 	"crypto/sha512"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
+	"os/signal"
+	"pouncecat/checkpoint"
 	"pouncecat/column"
 	"pouncecat/helpers"
+	"pouncecat/lookup"
+	"pouncecat/metrics"
+	"pouncecat/report"
+	"pouncecat/resolve"
+	"pouncecat/source"
 	"pouncecat/source/mongo"
 	"pouncecat/table"
 	"pouncecat/transform"
 	"pouncecat/ui"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/joho/godotenv"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/exp/slices"
 	"golang.org/x/text/cases"
@@ -36,6 +47,107 @@ func init() {
 }
 
 func main() {
+	var opts table.Options
+
+	flag.BoolVar(&opts.Evolve, "evolve", false, "Evolve existing tables in place instead of dropping the public schema")
+	flag.BoolVar(&opts.AllowDestructive, "allow-destructive", false, "Allow destructive schema changes (dropping columns, constraints and indexes) when evolving")
+	flag.StringVar(&opts.Schema, "schema", "public", "Schema to migrate into")
+	flag.BoolVar(&opts.Swap, "swap", false, "Migrate into a staging schema and swap it into place once all tables succeed")
+	flag.BoolVar(&opts.Transactional, "transactional", false, "Migrate each table in a single transaction, rolling it back on failure")
+	flag.BoolVar(&opts.Incremental, "incremental", false, "Upsert changed records into the existing tables instead of reloading them")
+	flag.BoolVar(&opts.Resume, "resume", false, "Skip tables completed by a previous run and continue partially migrated ones")
+
+	watch := flag.Bool("watch", false, "After migrating, keep syncing changes from the mongo change stream until interrupted")
+	resumeTokenFile := flag.String("resume-token-file", "pouncecat_resume_token.json", "File the change stream position is stored in with -watch")
+	preImages := flag.Bool("pre-images", false, "Sync deletes with -watch using change stream pre-images (mongo 6.0+ with changeStreamPreAndPostImages enabled)")
+
+	verify := flag.Bool("verify", false, "Verify a previous migration instead of migrating")
+	verifySamples := flag.Int("verify-samples", 100, "Number of random source records per table to compare with -verify")
+	verifyBuckets := flag.Int("verify-buckets", 0, "Number of key ranges to compare checksums of all rows in with -verify, 0 to skip checksums")
+	verifyJSON := flag.String("verify-json", "", "File to write the -verify results to as JSON")
+
+	reportJSON := flag.String("report-json", "", "File to write the run report to as JSON")
+	reportMarkdown := flag.String("report-md", "", "File to write the run report to as Markdown")
+	reportHTML := flag.String("report-html", "", "File to write the run report to as HTML")
+
+	logLevel := flag.String("log-level", "", "Minimum level to log: debug, info, warning or error (defaults to info, or debug with DEBUG_SPAM=1)")
+	logFile := flag.String("log-file", "", "File to also write logs to as JSON lines")
+	noColor := flag.Bool("no-color", false, "Disable coloured console output")
+
+	progressMode := flag.String("progress", "auto", "How to show progress: bars, plain (periodic lines), none or auto (bars on a terminal, plain otherwise)")
+	progressInterval := flag.Duration("progress-interval", 10*time.Second, "How often to print progress lines with -progress=plain")
+
+	metricsAddr := flag.String("metrics-addr", "", "Address to serve Prometheus metrics on at /metrics (such as :9100), disabled if empty")
+
+	resolveFrontends := flag.String("resolve-frontends", "http", "Comma-separated frontends to answer questions on: http, terminal or none")
+	resolveAddr := flag.String("resolve-addr", "localhost:34012", "Address the http question frontend listens on")
+
+	answersFile := flag.String("answers-file", "pouncecat_answers.json", "File answers to questions are recorded in and reused from, empty to not record answers")
+	nonInteractive := flag.Bool("non-interactive", false, "Never ask questions, use recorded answers or -resolve-default")
+	resolveDefault := flag.String("resolve-default", "fail", "What to do with questions without a recorded answer in -non-interactive mode: fail or skip")
+
+	sourceDryRun := flag.Bool("source-dry-run", false, "Only log the changes transforms would make to source records")
+	mutationLog := flag.String("source-mutation-log", "pouncecat_source_mutations.jsonl", "File changes to source records are appended to as JSON lines, empty to only log them")
+
+	userCache := flag.String("user-cache", "pouncecat_user_cache.json", "File discord usernames are cached in across runs, empty to only cache in memory")
+
+	checkpointStore := flag.String("checkpoints", "file", "Where to store checkpoints: file, postgres (in the pouncecat schema) or none")
+	checkpointFile := flag.String("checkpoint-file", "pouncecat_state.json", "Checkpoint file to use with -checkpoints=file")
+
+	flag.Parse()
+
+	mode, err := ui.ParseProgressMode(*progressMode)
+
+	if err != nil {
+		panic(err)
+	}
+
+	ui.Reporter = ui.NewProgress(mode, *progressInterval)
+
+	if *logLevel != "" {
+		level, err := ui.ParseLevel(*logLevel)
+
+		if err != nil {
+			panic(err)
+		}
+
+		ui.Log.MinLevel = level
+	}
+
+	if *noColor {
+		ui.Log.Outputs = []ui.Output{ui.ConsoleOutput{Color: false}}
+	}
+
+	if *logFile != "" {
+		out, err := ui.NewJSONFileOutput(*logFile)
+
+		if err != nil {
+			panic(err)
+		}
+
+		ui.Log.Outputs = append(ui.Log.Outputs, out)
+	}
+
+	if err := opts.Validate(); err != nil {
+		panic(err)
+	}
+
+	// Cancelled on the first interrupt, migrations stop between rows and save their checkpoint
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-signals
+
+		// A second interrupt kills the process right away
+		signal.Reset(os.Interrupt, syscall.SIGTERM)
+
+		ui.NotifyMsg("warning", "Interrupted, finishing the current row (interrupt again to quit immediately)")
+		cancel()
+	}()
 
 	sess, err := discordgo.New("Bot " + os.Getenv("DISCORD_TOKEN"))
 
@@ -51,1073 +163,1252 @@ func main() {
 		panic(err)
 	}
 
-	source := mongo.MongoSource{
-		ConnectionURL:  os.Getenv("MONGO"),
-		DatabaseName:   "infinity",
-		IgnoreEntities: []string{"sessions"},
+	src := mongo.MongoSource{
+		ConnectionURL:   os.Getenv("MONGO"),
+		DatabaseName:    "infinity",
+		IgnoreEntities:  []string{"sessions"},
+		ResumeTokenFile: *resumeTokenFile,
+		PreImages:       *preImages,
 	}
 
-	err = source.Connect()
+	err = src.Connect(ctx)
 
 	if err != nil {
 		panic(err)
 	}
 
-	pool, err := pgxpool.Connect(context.Background(), "postgresql://127.0.0.1:5432/infinity?user=root&password=iblpublic")
+	poolCfg, err := pgxpool.ParseConfig("postgresql://127.0.0.1:5432/infinity?user=root&password=iblpublic")
 
 	if err != nil {
 		panic(err)
 	}
 
-	table.PrepareTables(pool)
-
-	table.Table{
-		SrcName:           "users",
-		DstName:           "users",
-		IgnoreUniqueError: true,
-		Columns: column.Columns(
-			column.NewText(
-				column.Source("userID"),
-				column.Dest("user_id"),
-				column.Default("SKIP"),
-				func(records map[string]any, p any) any {
-					if p == nil {
-						return p
-					}
-
-					userId := p.(string)
-
-					return strings.TrimSpace(userId)
-				},
-			).SetUnique(true),
-			column.NewText(
-				column.Source("username"),
-				column.Dest("username"),
-				nil,
-				func(records map[string]any, p any) any {
-					userId := records["userID"].(string)
-
-					username, ok := p.(string)
-
-					if ok && username != "" {
-						return username
-					}
-
-					// Call http://localhost:8080/_duser/ID
-					resp, err := http.Get("http://localhost:8080/_duser/" + userId)
-
-					if err != nil {
-						fmt.Println("User fetch error:", err)
-						return "Unknown User"
-					}
-
-					if resp.StatusCode != 200 {
-						fmt.Println("User fetch error:", resp.StatusCode)
-						return "Unknown User"
-					}
-
-					// Read the response body
-					body, err := io.ReadAll(resp.Body)
-
-					if err != nil {
-						fmt.Println("User fetch error:", err)
-						return "Unknown User"
-					}
-
-					var data struct {
-						Username string `json:"username"`
-					}
-
-					// Unmarshal the response body
-					err = json.Unmarshal(body, &data)
-
-					if err != nil {
-						fmt.Println("User fetch error:", err)
-						return "Unknown User"
-					}
-
-					return data.Username
-				},
-			),
-			column.NewBool(
-				column.Source("staff_onboarded"),
-				column.Dest("staff_onboarded"),
-				column.Default(false),
-			),
-			column.NewText(
-				column.Source("staff_onboard_state"),
-				column.Dest("staff_onboard_state"),
-				column.Default("pending"),
-			),
-			column.NewTimestamp(
-				column.Source("staff_onboard_last_start_time"),
-				column.Dest("staff_onboard_last_start_time"),
-				"",
-			).SetNullable(true),
-			column.NewTimestamp(
-				column.Source("staff_onboard_macro_time"),
-				column.Dest("staff_onboard_macro_time"),
-				"",
-			).SetNullable(true),
-			column.NewText(
-				column.Source("staff_onboard_session_code"),
-				column.Dest("staff_onboard_session_code"),
-				nil,
-			).SetNullable(true),
-			column.NewBool(
-				column.Source("staff"),
-				column.Dest("staff"),
-				column.Default(false),
-			),
-			column.NewBool(
-				column.Source("admin"),
-				column.Dest("admin"),
-				column.Default(false),
-			),
-			column.NewBool(
-				column.Source("hadmin"),
-				column.Dest("hadmin"),
-				column.Default(false),
-			),
-			column.NewJSONB(
-				column.Source("extra_links"),
-				column.Dest("extra_links"),
-				func(record map[string]any, col any) any {
-					parsedLinks := []link{}
-					for _, name := range []string{"website", "github"} {
-						linkC, ok := record[name]
+	// So the raw queries in transforms below see the tables being migrated. Changed once the staging schema is swapped in
+	var searchPath atomic.Value
+	searchPath.Store(opts.SearchPath())
 
-						if !ok {
-							continue
-						}
+	poolCfg.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+		_, err := conn.Exec(ctx, "SET search_path TO "+searchPath.Load().(string))
+		return err
+	}
 
-						linkStr, ok := linkC.(string)
+	pool, err := pgxpool.ConnectConfig(context.Background(), poolCfg)
 
-						if !ok {
-							continue
-						}
+	if err != nil {
+		panic(err)
+	}
 
-						// Title-case name
-						name = cases.Title(language.AmericanEnglish).String(name)
+	// New connections pick the path up in AfterConnect, idle ones are changed here. Connections in use are not
+	// changed, so this should only be called between tables
+	setSearchPath := func(path string) {
+		searchPath.Store(path)
 
-						parsedLink := parseLink(name, linkStr)
+		for _, conn := range pool.AcquireAllIdle(ctx) {
+			if _, err := conn.Exec(ctx, "SET search_path TO "+path); err != nil {
+				panic(err)
+			}
 
-						if parsedLink != "" {
-							parsedLinks = append(parsedLinks, link{
-								Name:  name,
-								Value: parsedLink,
-							})
-						}
-					}
+			conn.Release()
+		}
+	}
 
-					return parsedLinks
-				},
-			),
-			column.NewText(
-				column.Source("apiToken"),
-				column.Dest("api_token"),
-				nil,
-				func(record map[string]any, col any) any {
-					if col == nil {
-						return helpers.RandString(128)
-					}
-
-					return col
-				},
-			),
-			column.NewText(
-				column.Source("about"),
-				column.Dest("about"),
-				"I am a very mysterious person",
-			),
-			column.NewBool(
-				column.Source("vote_banned"),
-				column.Dest("vote_banned"),
-				column.Default(false),
-			),
-		),
-	}.Migrate(source, pool)
-
-	table.Table{
-		SrcName: "apps",
-		DstName: "apps",
-		Columns: column.Columns(
-			column.NewText(
-				column.Source("appID"),
-				column.Dest("app_id"),
-				column.NoDefault,
-			),
-			column.NewText(
-				column.Source("userID"),
-				column.Dest("user_id"),
-				column.NoDefault,
-			).SetForeignKey([2]string{"users", "user_id"}),
-			column.NewText(
-				column.Source("position"),
-				column.Dest("position"),
-				column.NoDefault,
-			),
-			column.NewTimestamp(
-				column.Source("created_at"),
-				column.Dest("created_at"),
-				"NOW()", // Default to now
-				transform.ToTimestamp,
-			),
-			column.NewJSONB(
-				column.Source("answers"),
-				column.Dest("answers"),
-			),
-			column.NewJSONB(
-				column.Source("interviewAnswers"),
-				column.Dest("interview_answers"),
-			),
-			column.NewText(
-				column.Source("state"),
-				column.Dest("state"),
-				"pending",
-			),
-			column.NewBigInt(
-				column.Source("likes"),
-				column.Dest("likes"),
-				column.ArrayJSONDefault,
-			).SetArray(true),
-			column.NewBigInt(
-				column.Source("dislikes"),
-				column.Dest("dislikes"),
-				column.ArrayJSONDefault,
-			).SetArray(true),
-		),
-	}.Migrate(source, pool)
-
-	table.Table{
-		SrcName:   "bots",
-		DstName:   "bots",
-		IndexCols: []string{"bot_id", "staff_bot", "cross_add", "api_token", "lower(vanity)"},
-		Columns: column.Columns(
-			column.NewText(
-				column.Source("botID"),
-				column.Dest("bot_id"),
-				column.Default("SKIP"),
-				func(records map[string]any, p any) any {
-					if p == nil {
-						return p
-					}
-
-					userId := p.(string)
-
-					return strings.TrimSpace(userId)
-				},
-			).SetUnique(true),
-			column.NewText(
-				column.Source("clientID"),
-				column.Dest("client_id"),
-				column.Default(column.NoDefault),
-				func(record map[string]any, col any) any {
-					botId := record["botID"].(string)
-
-					if col == nil {
-						ui.NotifyMsg("info", "No client ID for bot "+botId+", finding one")
-						// Call http://localhost:8080/_duser/ID
-						resp, err := http.Get("http://localhost:8080/_duser/" + botId)
-
-						if err != nil {
-							fmt.Println("User fetch error:", err)
-							return "SKIP"
-						}
+	switch *checkpointStore {
+	case "file":
+		opts.Checkpoints = &checkpoint.FileStore{Path: *checkpointFile}
+	case "postgres":
+		opts.Checkpoints = &checkpoint.PostgresStore{Pool: pool}
+	case "none":
+	default:
+		panic("unknown checkpoint store " + *checkpointStore)
+	}
 
-						if resp.StatusCode != 200 {
-							fmt.Println("User fetch error:", resp.StatusCode)
-							return "SKIP"
-						}
+	resolver := resolve.NewManager()
+	resolver.NonInteractive = *nonInteractive
 
-						_, rerr := sess.Request("GET", "https://discord.com/api/v10/applications/"+botId+"/rpc", nil)
+	if *answersFile != "" {
+		resolver.Answers = &resolve.AnswersFile{Path: *answersFile}
+	}
 
-						if rerr == nil {
-							source.Conn.Database("infinity").Collection("bots").UpdateOne(context.Background(), bson.M{
-								"botID": botId,
-							}, bson.M{
-								"$set": bson.M{
-									"clientID": botId,
-								},
-							})
-						}
+	switch *resolveDefault {
+	case "fail":
+	case "skip":
+		resolver.Default = &resolve.Answer{Kind: resolve.AnswerSkip}
+	default:
+		panic("unknown resolve default " + *resolveDefault)
+	}
 
-						for rerr != nil {
-							clientId := helpers.PromptServerChannel("What is the client ID for " + botId + "?")
+	for _, name := range strings.Split(*resolveFrontends, ",") {
+		switch strings.TrimSpace(name) {
+		case "http":
+			resolver.Frontends = append(resolver.Frontends, &resolve.HTTPFrontend{Addr: *resolveAddr})
+		case "terminal":
+			resolver.Frontends = append(resolver.Frontends, &resolve.TerminalFrontend{})
+		case "none", "":
+		default:
+			panic("unknown resolve frontend " + name)
+		}
+	}
 
-							if clientId == "DEL" {
-								source.Conn.Database("infinity").Collection("bots").DeleteOne(context.Background(), bson.M{"botID": botId})
-								return "SKIP"
+	// Nothing will be asked, so do not bind the http frontend
+	if !resolver.NonInteractive {
+		if err := resolver.Start(); err != nil {
+			panic(err)
+		}
+	}
+
+	defer resolver.Close()
+
+	opts.Resolver = resolver
+
+	// Transforms change source records through this, so every change is logged
+	opts.SourceWriter = &source.MutationLog{
+		Source: src,
+		DryRun: *sourceDryRun,
+		Path:   *mutationLog,
+	}
+
+	// Looks up discord users through the local user service
+	discordUsers := &transform.Enricher{
+		Resolver: &transform.HTTPResolver{
+			URL:   "http://localhost:8080/_duser/{key}",
+			Field: "username",
+		},
+		CachePath: *userCache,
+		Fallback:  "Unknown User",
+	}
+
+	// Lets transforms read other tables and source entities
+	lookups := lookup.New(src, pool, opts.TargetSchema())
+
+	tables := []table.Table{
+		{
+			SrcName:     "users",
+			DstName:     "users",
+			ConflictKey: []string{"user_id"},
+			Filters: []table.Filter{
+				table.Expr("userID != null"),
+			},
+			// Some users were created more than once
+			Dedup: &table.Dedup{
+				Key:      []string{"userID"},
+				Strategy: table.DedupFirst,
+				Normalize: func(v any) string {
+					return strings.TrimSpace(fmt.Sprint(v))
+				},
+			},
+			Columns: column.Columns(
+				column.NewText(
+					column.Source("userID"),
+					column.Dest("user_id"),
+					column.NoDefault,
+					func(records map[string]any, p any) any {
+						if p == nil {
+							return p
+						}
+
+						userId := p.(string)
+
+						return strings.TrimSpace(userId)
+					},
+				).SetUnique(true),
+				column.NewText(
+					column.Source("username"),
+					column.Dest("username"),
+					nil,
+				).AddContextTransform(discordUsers.FillMissing("userID")).SetVolatile(true),
+				column.NewBool(
+					column.Source("staff_onboarded"),
+					column.Dest("staff_onboarded"),
+					column.Default(false),
+				),
+				column.NewText(
+					column.Source("staff_onboard_state"),
+					column.Dest("staff_onboard_state"),
+					column.Default("pending"),
+				),
+				column.NewTimestamp(
+					column.Source("staff_onboard_last_start_time"),
+					column.Dest("staff_onboard_last_start_time"),
+					"",
+				).SetNullable(true),
+				column.NewTimestamp(
+					column.Source("staff_onboard_macro_time"),
+					column.Dest("staff_onboard_macro_time"),
+					"",
+				).SetNullable(true),
+				column.NewText(
+					column.Source("staff_onboard_session_code"),
+					column.Dest("staff_onboard_session_code"),
+					nil,
+				).SetNullable(true),
+				column.NewBool(
+					column.Source("staff"),
+					column.Dest("staff"),
+					column.Default(false),
+				),
+				column.NewBool(
+					column.Source("admin"),
+					column.Dest("admin"),
+					column.Default(false),
+				),
+				column.NewBool(
+					column.Source("hadmin"),
+					column.Dest("hadmin"),
+					column.Default(false),
+				),
+				column.NewJSONB(
+					column.Source("extra_links"),
+					column.Dest("extra_links"),
+					func(record map[string]any, col any) any {
+						parsedLinks := []link{}
+						for _, name := range []string{"website", "github"} {
+							linkC, ok := record[name]
+
+							if !ok {
+								continue
 							}
 
-							_, rerr = sess.Request("GET", "https://discord.com/api/v10/applications/"+clientId+"/rpc", nil)
+							linkStr, ok := linkC.(string)
 
-							if rerr != nil {
-								fmt.Println("Client ID fetch error:", rerr)
+							if !ok {
 								continue
 							}
 
-							source.Conn.Database("infinity").Collection("bots").UpdateOne(context.Background(), bson.M{
-								"botID": botId,
-							}, bson.M{
-								"$set": bson.M{
-									"clientID": clientId,
-								},
-							})
+							// Title-case name
+							name = cases.Title(language.AmericanEnglish).String(name)
 
-							return clientId
+							parsedLink := parseLink(name, linkStr)
+
+							if parsedLink != "" {
+								parsedLinks = append(parsedLinks, link{
+									Name:  name,
+									Value: parsedLink,
+								})
+							}
 						}
 
-						return botId
-					}
+						return parsedLinks
+					},
+				),
+				column.NewText(
+					column.Source("apiToken"),
+					column.Dest("api_token"),
+					nil,
+					func(record map[string]any, col any) any {
+						if col == nil {
+							return helpers.RandString(128)
+						}
 
-					return col
-				},
-			),
-			column.NewText(
-				column.Source("botName"),
-				column.Dest("queue_name"),
-				column.NoDefault,
-			),
-			column.NewText(
-				column.Source("tags"),
-				column.Dest("tags"),
-				column.ArrayJSONDefault,
-				transform.ToList,
-			).SetArray(true),
-			column.NewText(
-				column.Source("prefix"),
-				column.Dest("prefix"),
-				column.Default("/"),
-			),
-			column.NewText(
-				column.Source("main_owner"),
-				column.Dest("owner"),
-				column.Default("PANIC"),
-				func(records map[string]any, p any) any {
-					if p == nil {
-						return p
-					}
+						return col
+					},
+				).SetVolatile(true),
+				column.NewText(
+					column.Source("about"),
+					column.Dest("about"),
+					"I am a very mysterious person",
+				),
+				column.NewBool(
+					column.Source("vote_banned"),
+					column.Dest("vote_banned"),
+					column.Default(false),
+				),
+			),
+		},
+
+		{
+			SrcName: "apps",
+			DstName: "apps",
+			Columns: column.Columns(
+				column.NewText(
+					column.Source("appID"),
+					column.Dest("app_id"),
+					column.NoDefault,
+				),
+				column.NewText(
+					column.Source("userID"),
+					column.Dest("user_id"),
+					column.NoDefault,
+				).SetForeignKey([2]string{"users", "user_id"}),
+				column.NewText(
+					column.Source("position"),
+					column.Dest("position"),
+					column.NoDefault,
+				),
+				column.NewTimestamp(
+					column.Source("created_at"),
+					column.Dest("created_at"),
+					"NOW()", // Default to now
+					transform.ToTimestamp,
+				),
+				column.NewJSONB(
+					column.Source("answers"),
+					column.Dest("answers"),
+				),
+				column.NewJSONB(
+					column.Source("interviewAnswers"),
+					column.Dest("interview_answers"),
+				),
+				column.NewText(
+					column.Source("state"),
+					column.Dest("state"),
+					"pending",
+				),
+				column.NewBigInt(
+					column.Source("likes"),
+					column.Dest("likes"),
+					column.ArrayJSONDefault,
+				).SetArray(true),
+				column.NewBigInt(
+					column.Source("dislikes"),
+					column.Dest("dislikes"),
+					column.ArrayJSONDefault,
+				).SetArray(true),
+			),
+		},
+
+		{
+			SrcName:     "bots",
+			DstName:     "bots",
+			ConflictKey: []string{"bot_id"},
+			IndexCols:   []string{"bot_id", "staff_bot", "cross_add", "api_token", "lower(vanity)"},
+			Indexes: table.Indexes(
+				table.NewIndex("bots_tags_idx", "tags").SetMethod(table.IndexMethodGIN),
+			),
+			Columns: column.Columns(
+				column.NewText(
+					column.Source("botID"),
+					column.Dest("bot_id"),
+					column.Default("SKIP"),
+					func(records map[string]any, p any) any {
+						if p == nil {
+							return p
+						}
 
-					userId := p.(string)
+						userId := p.(string)
+
+						return strings.TrimSpace(userId)
+					},
+				).SetUnique(true),
+				column.NewText(
+					column.Source("clientID"),
+					column.Dest("client_id"),
+					column.Default(column.NoDefault),
+				).AddContextTransform(
+					func(tc *column.TransformContext, record map[string]any, col any) any {
+						botId := record["botID"].(string)
+
+						if col == nil {
+							tc.Log.Info("No client ID, finding one", ui.F("bot", botId), ui.F("row", tc.Row))
+
+							// Bots discord no longer knows about are not worth keeping
+							if _, err := discordUsers.Lookup(tc.Ctx, botId); err != nil {
+								tc.Log.Warn("User fetch failed, skipping bot", ui.F("bot", botId), ui.F("error", err))
+								return "SKIP"
+							}
 
-					userId = strings.TrimSpace(userId)
+							_, rerr := sess.Request("GET", "https://discord.com/api/v10/applications/"+botId+"/rpc", nil)
 
-					var count int64
+							if rerr == nil && tc.Writer != nil {
+								tc.Writer.UpdateRecord(tc.Ctx, "bots", "botID", botId, map[string]any{"clientID": botId})
+							}
 
-					err := pool.QueryRow(context.Background(), "SELECT COUNT(*) FROM users WHERE user_id = $1", userId).Scan(&count)
+							if rerr != nil {
+								question := &resolve.Question{
+									Prompt:      "What is the client ID for " + botId + "?",
+									Name:        "client_id",
+									Table:       "bots",
+									Key:         botId,
+									Record:      record,
+									AllowDelete: true,
+								}
+
+								// Asked in bulk along with the other bots missing one
+								return resolve.Defer(question, func(answer resolve.Answer) any {
+									// Asked again until discord accepts the client ID
+									for {
+										switch answer.Kind {
+										case resolve.AnswerDelete:
+											if tc.Writer != nil {
+												tc.Writer.DeleteRecord(tc.Ctx, "bots", "botID", botId)
+											}
+
+											return "SKIP"
+										case resolve.AnswerSkip:
+											return "SKIP"
+										}
+
+										clientId := answer.Value
+
+										_, err := sess.Request("GET", "https://discord.com/api/v10/applications/"+clientId+"/rpc", nil)
+
+										if err == nil {
+											if tc.Writer != nil {
+												tc.Writer.UpdateRecord(tc.Ctx, "bots", "botID", botId, map[string]any{"clientID": clientId})
+											}
+
+											return clientId
+										}
+
+										tc.Log.Warn("Client ID fetch failed", ui.F("bot", botId), ui.F("client_id", clientId), ui.F("error", err))
+										resolver.Forget(question)
+
+										// Nobody to ask, the recorded answer is forgotten so the next run asks again
+										if resolver.NonInteractive {
+											return "SKIP"
+										}
+
+										question.Prompt = "Discord does not know " + clientId + ", what is the client ID for " + botId + "?"
+										answer = resolver.Ask(tc.Ctx, question)
+									}
+								})
+							}
 
-					if err != nil {
-						panic(err)
-					}
+							return botId
+						}
 
-					if count == 0 {
-						ui.NotifyMsg("warning", "User not found, adding")
+						return col
+					},
+				).SetVolatile(true),
+				column.NewText(
+					column.Source("botName"),
+					column.Dest("queue_name"),
+					column.NoDefault,
+				),
+				column.NewText(
+					column.Source("tags"),
+					column.Dest("tags"),
+					column.ArrayJSONDefault,
+					transform.ToList,
+				).SetArray(true),
+				column.NewText(
+					column.Source("prefix"),
+					column.Dest("prefix"),
+					column.Default("/"),
+				),
+				column.NewText(
+					column.Source("main_owner"),
+					column.Dest("owner"),
+					column.Default("PANIC"),
+					func(records map[string]any, p any) any {
+						if p == nil {
+							return p
+						}
 
-						var username string
+						return strings.TrimSpace(p.(string))
+					},
+				).SetForeignKey([2]string{"users", "user_id"}).SetEnsureParent(&column.ParentStub{
+					Values: map[string]any{
+						"username": column.StubValue(func(ctx context.Context, key any) any {
+							return discordUsers.LookupOrFallback(ctx, fmt.Sprint(key))
+						}),
+						"api_token": column.StubValue(func(ctx context.Context, key any) any {
+							return helpers.RandString(128)
+						}),
+					},
+				}),
+				column.NewText(
+					column.Source("additional_owners"),
+					column.Dest("additional_owners"),
+					column.ArrayJSONDefault,
+				).SetArray(true),
+				column.NewBool(
+					column.Source("staff"),
+					column.Dest("staff_bot"),
+					column.Default(false),
+				),
+				column.NewText(
+					column.Source("short"),
+					column.Dest("short"),
+					column.Default("PANIC"),
+				),
+				column.NewText(
+					column.Source("long"),
+					column.Dest("long"),
+					column.Default("PANIC"),
+				),
+				column.NewText(
+					column.Source("library"),
+					column.Dest("library"),
+					column.Default("custom"),
+				),
+				column.NewJSONB(
+					column.Source("extra_links"),
+					column.Dest("extra_links"),
+					func(record map[string]any, col any) any {
+						parsedLinks := []link{}
+						for _, name := range []string{"website", "github", "donate", "support"} {
+							linkC, ok := record[name]
+
+							if !ok {
+								continue
+							}
 
-						// Call http://localhost:8080/_duser/ID
-						resp, err := http.Get("http://localhost:8080/_duser/" + userId)
+							linkStr, ok := linkC.(string)
 
-						if err != nil {
-							fmt.Println("User fetch error:", err)
-							username = "Unknown User"
-						}
+							if !ok {
+								continue
+							}
 
-						if resp.StatusCode != 200 {
-							fmt.Println("User fetch error:", resp.StatusCode)
-							username = "Unknown User"
-						}
+							// Title-case name
+							name = cases.Title(language.AmericanEnglish).String(name)
 
-						// Read the response body
-						body, err := io.ReadAll(resp.Body)
+							parsedLink := parseLink(name, linkStr)
 
-						if err != nil {
-							fmt.Println("User fetch error:", err)
-							username = "Unknown User"
+							if parsedLink != "" {
+								parsedLinks = append(parsedLinks, link{
+									Name:  name,
+									Value: parsedLink,
+								})
+							}
 						}
 
-						var data struct {
-							Username string `json:"username"`
+						return parsedLinks
+					},
+				),
+				column.NewBool(
+					column.Source("nsfw"),
+					column.Dest("nsfw"),
+					column.Default(false),
+				),
+				column.NewBool(
+					column.Source("premium"),
+					column.Dest("premium"),
+					column.Default(false),
+				),
+				column.NewBool(
+					column.Source("pending_cert"),
+					column.Dest("pending_cert"),
+					column.Default(false),
+				),
+				column.NewBigInt(
+					column.Source("servers"),
+					column.Dest("servers"),
+					column.Default(0),
+				),
+				column.NewBigInt(
+					column.Source("shards"),
+					column.Dest("shards"),
+					column.Default(0),
+				),
+				column.NewBigInt(
+					column.Source("users"),
+					column.Dest("users"),
+					column.Default(0),
+				),
+				column.NewInt(
+					column.Source("shardArray"),
+					column.Dest("shard_array"),
+					column.Default(column.ArrayJSONDefault),
+				).SetArray(true),
+				column.NewInt(
+					column.Source("votes"),
+					column.Dest("votes"),
+					column.Default(0),
+				),
+				column.NewInt(
+					column.Source("clicks"),
+					column.Dest("clicks"),
+					column.Default(0),
+				),
+				column.NewInt(
+					column.Source("invite_clicks"),
+					column.Dest("invite_clicks"),
+					column.Default(0),
+				),
+				column.NewText(
+					column.Source("background"),
+					column.Dest("banner"),
+					nil,
+				).SetNullable(true),
+				column.NewText(
+					column.Source("invite"),
+					column.Dest("invite"),
+					nil,
+				).SetNullable(true),
+				column.NewText(
+					column.Source("type"),
+					column.Dest("type"),
+					column.Default("pending"),
+					func(record map[string]any, col any) any {
+						certified, ok := record["certified"].(bool)
+
+						if certified && ok {
+							return "certified"
 						}
 
-						// Unmarshal the response body
-						err = json.Unmarshal(body, &data)
+						claimed, ok := record["claimed"].(bool)
 
-						if err != nil {
-							fmt.Println("User fetch error:", err)
-							username = "Unknown User"
+						if ok && claimed {
+							return "claimed"
 						}
 
-						username = data.Username
-
-						if _, err = pool.Exec(context.Background(), "INSERT INTO users (username, user_id, api_token) VALUES ($1, $2, $3)", username, p, helpers.RandString(128)); err != nil {
-							panic(err)
+						return col
+					},
+				),
+				column.NewText(
+					column.Source("vanity"),
+					column.Dest("vanity"),
+					column.Default("PANIC"),
+				).AddContextTransform(
+					func(tc *column.TransformContext, record map[string]any, col any) any {
+						if col == nil {
+							// Generate vanity as random string
+							return helpers.RandString(8)
 						}
-					}
 
-					return userId
-				},
-			),
-			column.NewText(
-				column.Source("additional_owners"),
-				column.Dest("additional_owners"),
-				column.ArrayJSONDefault,
-			).SetArray(true),
-			column.NewBool(
-				column.Source("staff"),
-				column.Dest("staff_bot"),
-				column.Default(false),
-			),
-			column.NewText(
-				column.Source("short"),
-				column.Dest("short"),
-				column.Default("PANIC"),
-			),
-			column.NewText(
-				column.Source("long"),
-				column.Dest("long"),
-				column.Default("PANIC"),
-			),
-			column.NewText(
-				column.Source("library"),
-				column.Dest("library"),
-				column.Default("custom"),
-			),
-			column.NewJSONB(
-				column.Source("extra_links"),
-				column.Dest("extra_links"),
-				func(record map[string]any, col any) any {
-					parsedLinks := []link{}
-					for _, name := range []string{"website", "github", "donate", "support"} {
-						linkC, ok := record[name]
+						// Check that vanity is not taken
+						var colCast = col.(string)
 
-						if !ok {
-							continue
+						if len(lookups.Index(tc.Ctx, "bots", "vanity", true).All(colCast)) > 1 {
+							return helpers.RandString(8)
 						}
 
-						linkStr, ok := linkC.(string)
+						return colCast
+					},
+				).SetUnique(true).SetVolatile(true),
+				column.NewText(
+					column.Source("external_source"),
+					column.Dest("external_source"),
+					nil,
+				).SetNullable(true),
+				column.NewUUID(
+					column.Source("listSource"),
+					column.Dest("list_source"),
+					"NULL",
+				).SetNullable(true),
+				column.NewBool(
+					column.Source("vote_banned"),
+					column.Dest("vote_banned"),
+					column.Default(false),
+				),
+				column.NewBool(
+					column.Source("cross_add"),
+					column.Dest("cross_add"),
+					column.Default(true),
+				),
+				column.NewBigInt(
+					column.Source("start_period"),
+					column.Dest("start_premium_period"),
+					column.Default(0),
+				),
+				column.NewBigInt(
+					column.Source("sub_period"),
+					column.Dest("premium_period_length"),
+					column.Default(0),
+				),
+				column.NewText(
+					column.Source("cert_reason"),
+					column.Dest("cert_reason"),
+					nil,
+				).SetNullable(true),
+				column.NewBool(
+					column.Source("announce"),
+					column.Dest("announce"),
+					column.Default(false),
+				),
+				column.NewText(
+					column.Source("announce_msg"),
+					column.Dest("announce_message"),
+					nil,
+				).SetNullable(true),
+				column.NewBigInt(
+					column.Source("uptime"),
+					column.Dest("uptime"),
+					column.Default(0),
+				),
+				column.NewBigInt(
+					column.Source("total_uptime"),
+					column.Dest("total_uptime"),
+					column.Default(0),
+				),
+				column.NewBigInt(
+					column.Source("claimedBy"),
+					column.Dest("claimed_by"),
+					nil,
+				).SetNullable(true),
+				column.NewText(
+					column.Source("note"),
+					column.Dest("approval_note"),
+					nil,
+				).SetNullable(true),
+				column.NewTimestamp(
+					column.Source("date"),
+					column.Dest("created_at"),
+					"NOW()",
+					transform.ToTimestamp,
+				),
+				column.NewText(
+					column.Source("webAuth"),
+					column.Dest("web_auth"),
+					nil,
+				).SetNullable(true),
+				column.NewText(
+					column.Source("webURL"),
+					column.Dest("webhook"),
+					nil,
+				).SetNullable(true),
+				column.NewBool(
+					column.Source("webHmac"),
+					column.Dest("hmac"),
+					column.Default(false),
+				),
+				column.NewText(
+					column.Source("unique_clicks"),
+					column.Dest("unique_clicks"),
+					column.ArrayJSONDefault,
+					func(record map[string]any, col any) any {
+						uc, ok := record["unique_clicks"].(primitive.A)
 
 						if !ok {
-							continue
+							return []string{}
 						}
 
-						// Title-case name
-						name = cases.Title(language.AmericanEnglish).String(name)
+						var uniqueClicks []string = make([]string, len(uc))
+						for i, v := range uc {
+							// hash v using sha512
+							h := sha512.New()
 
-						parsedLink := parseLink(name, linkStr)
+							h.Write([]byte(v.(string)))
 
-						if parsedLink != "" {
-							parsedLinks = append(parsedLinks, link{
-								Name:  name,
-								Value: parsedLink,
-							})
+							uniqueClicks[i] = hex.EncodeToString(h.Sum(nil))
 						}
-					}
 
-					return parsedLinks
-				},
-			),
-			column.NewBool(
-				column.Source("nsfw"),
-				column.Dest("nsfw"),
-				column.Default(false),
-			),
-			column.NewBool(
-				column.Source("premium"),
-				column.Dest("premium"),
-				column.Default(false),
-			),
-			column.NewBool(
-				column.Source("pending_cert"),
-				column.Dest("pending_cert"),
-				column.Default(false),
-			),
-			column.NewBigInt(
-				column.Source("servers"),
-				column.Dest("servers"),
-				column.Default(0),
-			),
-			column.NewBigInt(
-				column.Source("shards"),
-				column.Dest("shards"),
-				column.Default(0),
-			),
-			column.NewBigInt(
-				column.Source("users"),
-				column.Dest("users"),
-				column.Default(0),
-			),
-			column.NewInt(
-				column.Source("shardArray"),
-				column.Dest("shard_array"),
-				column.Default(column.ArrayJSONDefault),
-			).SetArray(true),
-			column.NewInt(
-				column.Source("votes"),
-				column.Dest("votes"),
-				column.Default(0),
-			),
-			column.NewInt(
-				column.Source("clicks"),
-				column.Dest("clicks"),
-				column.Default(0),
-			),
-			column.NewInt(
-				column.Source("invite_clicks"),
-				column.Dest("invite_clicks"),
-				column.Default(0),
-			),
-			column.NewText(
-				column.Source("background"),
-				column.Dest("banner"),
-				nil,
-			).SetNullable(true),
-			column.NewText(
-				column.Source("invite"),
-				column.Dest("invite"),
-				nil,
-			).SetNullable(true),
-			column.NewText(
-				column.Source("type"),
-				column.Dest("type"),
-				column.Default("pending"),
-				func(record map[string]any, col any) any {
-					certified, ok := record["certified"].(bool)
-
-					if certified && ok {
-						return "certified"
-					}
-
-					claimed, ok := record["claimed"].(bool)
-
-					if ok && claimed {
-						return "claimed"
-					}
-
-					return col
-				},
+						return uniqueClicks
+					},
+				).SetArray(true),
+				column.NewText(
+					column.Source("token"),
+					column.Dest("api_token"),
+					nil,
+				).SetSQLDefault("uuid_generate_v4()"),
+				column.NewTimestamp(
+					column.Source("last_claimed"),
+					column.Dest("last_claimed"),
+					"NULL",
+					transform.ToTimestamp,
+				).SetNullable(true),
+			),
+		},
+
+		{
+			SrcName:     "claims",
+			DstName:     "reports",
+			ConflictKey: []string{"bot_id"},
+			/*
+				BotID       string    `bson:"botID" json:"bot_id" unique:"true" fkey:"bots,bot_id"`
+				ClaimedBy   string    `bson:"claimedBy" json:"claimed_by"`
+				Claimed     bool      `bson:"claimed" json:"claimed"`
+				ClaimedAt   time.Time `bson:"claimedAt" json:"claimed_at" default:"NOW()"`
+				UnclaimedAt time.Time `bson:"unclaimedAt" json:"unclaimed_at" default:"NOW()"`
+			*/
+			Columns: column.Columns(
+				column.NewText(
+					column.Source("botID"),
+					column.Dest("bot_id"),
+					column.NoDefault,
+				).SetUnique(true).SetForeignKey([2]string{"bots", "bot_id"}),
+				column.NewText(
+					column.Source("claimedBy"),
+					column.Dest("claimed_by"),
+					column.NoDefault,
+				),
+				column.NewBool(
+					column.Source("claimed"),
+					column.Dest("claimed"),
+					column.Default(false),
+				),
+				column.NewTimestamp(
+					column.Source("claimedAt"),
+					column.Dest("claimed_at"),
+					"NOW()",
+					transform.ToTimestamp,
+				),
+				column.NewTimestamp(
+					column.Source("unclaimedAt"),
+					column.Dest("unclaimed_at"),
+					"NOW()",
+					transform.ToTimestamp,
+				),
+			),
+		},
+
+		{
+			SrcName: "announcements",
+			DstName: "announcements",
+			Columns: column.Columns(
+				/*
+					UserID         string    `bson:"userID" json:"user_id" fkey:"users,user_id"`
+					AnnouncementID string    `bson:"announceID" json:"id" mark:"uuid" defaultfunc:"uuidgen" default:"uuid_generate_v4()" omit:"true"`
+					Title          string    `bson:"title" json:"title"`
+					Content        string    `bson:"content" json:"content"`
+					ModifiedDate   time.Time `bson:"modifiedDate" json:"modified_date" default:"NOW()"`
+					ExpiresDate    time.Time `bson:"expiresDate,omitempty" json:"expires_date" default:"NOW()"`
+					Status         string    `bson:"status" json:"status" default:"'active'"`
+					Targetted      bool      `bson:"targetted" json:"targetted" default:"false"`
+					Target         []string  `bson:"target,omitempty" json:"target" default:"null"`
+				*/
+				column.NewText(
+					column.Source("userID"),
+					column.Dest("user_id"),
+					column.NoDefault,
+				).SetForeignKey([2]string{"users", "user_id"}),
+				column.NewUUID(
+					column.Source("announceID"),
+					column.Dest("id"),
+					"NULL",
+				).SetSQLDefault("uuid_generate_v4()"),
+				column.NewText(
+					column.Source("title"),
+					column.Dest("title"),
+					column.NoDefault,
+				),
+				column.NewText(
+					column.Source("content"),
+					column.Dest("content"),
+					column.NoDefault,
+				),
+				column.NewTimestamp(
+					column.Source("modifiedDate"),
+					column.Dest("modified_date"),
+					"NOW()",
+					transform.ToTimestamp,
+				),
+				column.NewTimestamp(
+					column.Source("expiresDate"),
+					column.Dest("expires_date"),
+					"NOW()",
+				),
+				column.NewText(
+					column.Source("status"),
+					column.Dest("status"),
+					"active",
+				),
+				column.NewBool(
+					column.Source("targetted"),
+					column.Dest("targetted"),
+					false,
+				),
+				column.NewText(
+					column.Source("target"),
+					column.Dest("target"),
+					column.ArrayJSONDefault,
+				).SetArray(true),
+			),
+		},
+
+		{
+			SrcName:       "votes",
+			DstName:       "votes",
+			IgnoreFKError: true,
+			Columns: column.Columns(
+				/*
+								UserID string    `bson:"userID" json:"user_id" fkey:"users,user_id" fkignore:"true"`
+					BotID  string    `bson:"botID" json:"bot_id" fkey:"bots,bot_id"`
+					Date   time.Time `bson:"date" json:"date" default:"NOW()"`
+				*/
+				column.NewText(
+					column.Source("userID"),
+					column.Dest("user_id"),
+					column.NoDefault,
+				).SetForeignKey([2]string{"users", "user_id"}),
+				column.NewText(
+					column.Source("botID"),
+					column.Dest("bot_id"),
+					column.NoDefault,
+				).SetForeignKey([2]string{"bots", "bot_id"}),
+
+				column.NewTimestamp(
+					column.Source("date"),
+					column.Dest("date"),
+					"NOW()",
+					transform.ToTimestamp,
+				),
+			),
+		},
+
+		{
+			SrcName:     "packages",
+			DstName:     "packs",
+			ConflictKey: []string{"url"},
+			Columns: column.Columns(
+				/*
+					Owner   string    `bson:"owner" json:"owner" fkey:"users,user_id"`
+					Name    string    `bson:"name" json:"name" default:"'My pack'"`
+					Short   string    `bson:"short" json:"short"`
+					TagsRaw string    `bson:"tags" json:"tags" tolist:"true"`
+					URL     string    `bson:"url" json:"url" unique:"true"`
+					Date    time.Time `bson:"date" json:"date" default:"NOW()"`
+					Bots    []string  `bson:"bots" json:"bots" tolist:"true"`
+				*/
+				column.NewText(
+					column.Source("owner"),
+					column.Dest("owner"),
+					column.NoDefault,
+				).SetForeignKey([2]string{"users", "user_id"}),
+				column.NewText(
+					column.Source("name"),
+					column.Dest("name"),
+					"My pack",
+				),
+				column.NewText(
+					column.Source("short"),
+					column.Dest("short"),
+					column.NoDefault,
+				),
+				column.NewText(
+					column.Source("tags"),
+					column.Dest("tags"),
+					column.ArrayJSONDefault,
+					transform.ToList,
+				).SetArray(true),
+				column.NewText(
+					column.Source("url"),
+					column.Dest("url"),
+					column.NoDefault,
+				).SetUnique(true),
+				column.NewTimestamp(
+					column.Source("date"),
+					column.Dest("created_at"),
+					"NOW()",
+					transform.ToTimestamp,
+				),
+				column.NewText(
+					column.Source("bots"),
+					column.Dest("bots"),
+					column.ArrayJSONDefault,
+				).SetArray(true),
+			),
+		},
+
+		{
+			SrcName:       "reviews",
+			DstName:       "reviews",
+			IgnoreFKError: true,
+			Columns: column.Columns(
+				/*
+					ID pgtype.UUID `bson:"_id" json:"id" default:"uuid_generate_v4()"`
+					BotID       string         `bson:"botID" json:"bot_id" fkey:"bots,bot_id"`
+					Author      string         `bson:"author" json:"author" fkey:"users,user_id"`
+					Content     string         `bson:"content" json:"content" default:"'Very good bot!'"`
+					StarRate    int            `bson:"star_rate" json:"stars" default:"1"`
+					CreatedAt        time.Time      `bson:"date" json:"created_at" default:"NOW()"`
+					Parent	  string         `bson:"parent" json:"parent" fkey:"reviews,review_id"`
+				*/
+				column.NewUUID(
+					column.Source("id"),
+					column.Dest("id"),
+					"NULL",
+				).SetSQLDefault("uuid_generate_v4()"),
+				column.NewText(
+					column.Source("botID"),
+					column.Dest("bot_id"),
+					column.NoDefault,
+				).SetForeignKey([2]string{"bots", "bot_id"}),
+				column.NewText(
+					column.Source("author"),
+					column.Dest("author"),
+					column.NoDefault,
+				).SetForeignKey([2]string{"users", "user_id"}),
+				column.NewText(
+					column.Source("content"),
+					column.Dest("content"),
+					"Very good bot!",
+				),
+				column.NewInt(
+					column.Source("star_rate"),
+					column.Dest("stars"),
+					5,
+				),
+				column.NewTimestamp(
+					column.Source("date"),
+					column.Dest("created_at"),
+					"NOW()",
+					transform.ToTimestamp,
+				),
+			),
+		},
+
+		{
+			SrcName: "replies",
+			DstName: "replies",
+			Columns: column.Columns(
+				column.NewUUID(
+					column.Source("id"),
+					column.Dest("id"),
+					"NULL",
+				).SetSQLDefault("uuid_generate_v4()"),
+				column.NewText(
+					column.Source("author"),
+					column.Dest("author"),
+					column.NoDefault,
+				).SetForeignKey([2]string{"users", "user_id"}),
+				column.NewText(
+					column.Source("content"),
+					column.Dest("content"),
+					"Very good bot!",
+				),
+				column.NewInt(
+					column.Source("star_rate"),
+					column.Dest("star_rate"),
+					5,
+				),
+				column.NewTimestamp(
+					column.Source("date"),
+					column.Dest("created_at"),
+					"NOW()",
+					transform.ToTimestamp,
+				),
+				column.NewUUID(
+					column.Source("parent"),
+					column.Dest("parent"),
+					column.NoDefault,
+				).SetForeignKey([2]string{"reviews", "id"}),
+			),
+		},
+
+		{
+			SrcName:     "tickets",
+			DstName:     "tickets",
+			ConflictKey: []string{"id"},
+			Columns: column.Columns(
+				/*
+					ChannelID      string    `bson:"channelID" json:"channel_id"`
+					Topic          string    `bson:"topic" json:"topic" default:"'Support'"`
+					UserID         string    `bson:"userID" json:"user_id"` // No fkey here bc a user may not be a user on the table yet
+					TicketID       int       `bson:"ticketID" json:"id" unique:"true"`
+					LogURL         string    `bson:"logURL,omitempty" json:"log_url" default:"null"`
+					CloseUserID    string    `bson:"closeUserID,omitempty" json:"close_user_id" default:"null"`
+					Open           bool      `bson:"open" json:"open" default:"true"`
+					Date           time.Time `bson:"date" json:"date" default:"NOW()"`
+					PanelMessageID string    `bson:"panelMessageID,omitempty" json:"panel_message_id" default:"null"`
+					PanelChannelID string    `bson:"panelChannelID,omitempty" json:"panel_channel_id" default:"null"`
+				*/
+
+				column.NewText(
+					column.Source("channelID"),
+					column.Dest("channel_id"),
+					column.NoDefault,
+				),
+				column.NewText(
+					column.Source("topic"),
+					column.Dest("topic"),
+					"Support",
+				),
+				column.NewText(
+					column.Source("userID"),
+					column.Dest("user_id"),
+					column.NoDefault,
+				),
+				column.NewInt(
+					column.Source("ticketID"),
+					column.Dest("id"),
+					column.NoDefault,
+				).SetUnique(true),
+				column.NewText(
+					column.Source("logURL"),
+					column.Dest("log_url"),
+					"NULL",
+				).SetNullable(true),
+				column.NewText(
+					column.Source("closeUserID"),
+					column.Dest("close_user_id"),
+					"NULL",
+				).SetNullable(true),
+				column.NewBool(
+					column.Source("open"),
+					column.Dest("open"),
+					true,
+				),
+				column.NewTimestamp(
+					column.Source("date"),
+					column.Dest("created_at"),
+					"NOW()",
+					transform.ToTimestamp,
+				),
+				column.NewText(
+					column.Source("panelMessageID"),
+					column.Dest("panel_message_id"),
+					"NULL",
+				).SetNullable(true),
+				column.NewText(
+					column.Source("panelChannelID"),
+					column.Dest("panel_channel_id"),
+					"NULL",
+				).SetNullable(true),
+			),
+		},
+
+		{
+			SrcName: "transcripts",
+			DstName: "transcripts",
+			Columns: column.Columns(
+			/*
+				TicketID int            `bson:"ticketID" json:"id" fkey:"tickets,id"`
+				Data     map[string]any `bson:"data" json:"data" default:"{}"`
+				ClosedBy map[string]any `bson:"closedBy" json:"closed_by" default:"{}"`
+				OpenedBy map[string]any `bson:"openedBy" json:"opened_by" default:"{}"`
+			*/
 			),
-			column.NewText(
-				column.Source("vanity"),
-				column.Dest("vanity"),
-				column.Default("PANIC"),
-				func(record map[string]any, col any) any {
-					if col == nil {
-						// Generate vanity as random string
-						return helpers.RandString(8)
-					}
-
-					// Check that vanity is not taken
-					var colCast = col.(string)
-
-					var count int
-					for _, v := range table.Records {
-						vanity, ok := v["vanity"]
+		},
+	}
 
-						if !ok {
-							continue
-						}
+	if err := table.ValidateTables(tables); err != nil {
+		panic(err)
+	}
 
-						vanityStr, ok := vanity.(string)
+	if *verify {
+		results := table.VerifyTables(ctx, tables, src, pool, opts, *verifySamples, *verifyBuckets)
 
-						if !ok {
-							continue
-						}
+		table.WriteVerifyTable(os.Stdout, results)
 
-						if strings.EqualFold(vanityStr, colCast) {
-							count++
-						}
-					}
+		if *verifyJSON != "" {
+			bytes, err := json.MarshalIndent(results, "", "\t")
 
-					if count > 1 {
-						return helpers.RandString(8)
-					}
+			if err != nil {
+				panic(err)
+			}
 
-					return colCast
-				},
-			).SetUnique(true),
-			column.NewText(
-				column.Source("external_source"),
-				column.Dest("external_source"),
-				nil,
-			).SetNullable(true),
-			column.NewUUID(
-				column.Source("listSource"),
-				column.Dest("list_source"),
-				"NULL",
-			).SetNullable(true),
-			column.NewBool(
-				column.Source("vote_banned"),
-				column.Dest("vote_banned"),
-				column.Default(false),
-			),
-			column.NewBool(
-				column.Source("cross_add"),
-				column.Dest("cross_add"),
-				column.Default(true),
-			),
-			column.NewBigInt(
-				column.Source("start_period"),
-				column.Dest("start_premium_period"),
-				column.Default(0),
-			),
-			column.NewBigInt(
-				column.Source("sub_period"),
-				column.Dest("premium_period_length"),
-				column.Default(0),
-			),
-			column.NewText(
-				column.Source("cert_reason"),
-				column.Dest("cert_reason"),
-				nil,
-			).SetNullable(true),
-			column.NewBool(
-				column.Source("announce"),
-				column.Dest("announce"),
-				column.Default(false),
-			),
-			column.NewText(
-				column.Source("announce_msg"),
-				column.Dest("announce_message"),
-				nil,
-			).SetNullable(true),
-			column.NewBigInt(
-				column.Source("uptime"),
-				column.Dest("uptime"),
-				column.Default(0),
-			),
-			column.NewBigInt(
-				column.Source("total_uptime"),
-				column.Dest("total_uptime"),
-				column.Default(0),
-			),
-			column.NewBigInt(
-				column.Source("claimedBy"),
-				column.Dest("claimed_by"),
-				nil,
-			).SetNullable(true),
-			column.NewText(
-				column.Source("note"),
-				column.Dest("approval_note"),
-				nil,
-			).SetNullable(true),
-			column.NewTimestamp(
-				column.Source("date"),
-				column.Dest("created_at"),
-				"NOW()",
-				transform.ToTimestamp,
-			),
-			column.NewText(
-				column.Source("webAuth"),
-				column.Dest("web_auth"),
-				nil,
-			).SetNullable(true),
-			column.NewText(
-				column.Source("webURL"),
-				column.Dest("webhook"),
-				nil,
-			).SetNullable(true),
-			column.NewBool(
-				column.Source("webHmac"),
-				column.Dest("hmac"),
-				column.Default(false),
-			),
-			column.NewText(
-				column.Source("unique_clicks"),
-				column.Dest("unique_clicks"),
-				column.ArrayJSONDefault,
-				func(record map[string]any, col any) any {
-					uc, ok := record["unique_clicks"].(primitive.A)
+			if err := os.WriteFile(*verifyJSON, bytes, 0644); err != nil {
+				panic(err)
+			}
+		}
 
-					if !ok {
-						return []string{}
-					}
+		if err := discordUsers.Flush(); err != nil {
+			ui.Log.Warn("Could not save enrichment cache", ui.F("path", discordUsers.CachePath), ui.F("error", err))
+		}
 
-					var uniqueClicks []string = make([]string, len(uc))
-					for i, v := range uc {
-						// hash v using sha512
-						h := sha512.New()
+		for _, r := range results {
+			if !r.OK() {
+				os.Exit(1)
+			}
+		}
 
-						h.Write([]byte(v.(string)))
+		return
+	}
 
-						uniqueClicks[i] = hex.EncodeToString(h.Sum(nil))
-					}
+	if *watch {
+		// Changes made during the bulk migration are replayed by the watcher afterwards. A fresh run starts over, so
+		// the position of a previous run would replay changes from before it
+		if err := src.MarkPosition(ctx, table.SyncEntities(tables), opts.Resume); err != nil {
+			panic(err)
+		}
+	}
 
-					return uniqueClicks
-				},
-			).SetArray(true),
-			column.NewText(
-				column.Source("token"),
-				column.Dest("api_token"),
-				nil,
-			).SetSQLDefault("uuid_generate_v4()"),
-			column.NewTimestamp(
-				column.Source("last_claimed"),
-				column.Dest("last_claimed"),
-				"NULL",
-				transform.ToTimestamp,
-			).SetNullable(true),
-		),
-	}.Migrate(source, pool)
-
-	table.Table{
-		SrcName: "claims",
-		DstName: "reports",
-		/*
-			BotID       string    `bson:"botID" json:"bot_id" unique:"true" fkey:"bots,bot_id"`
-			ClaimedBy   string    `bson:"claimedBy" json:"claimed_by"`
-			Claimed     bool      `bson:"claimed" json:"claimed"`
-			ClaimedAt   time.Time `bson:"claimedAt" json:"claimed_at" default:"NOW()"`
-			UnclaimedAt time.Time `bson:"unclaimedAt" json:"unclaimed_at" default:"NOW()"`
-		*/
-		Columns: column.Columns(
-			column.NewText(
-				column.Source("botID"),
-				column.Dest("bot_id"),
-				column.NoDefault,
-			).SetUnique(true).SetForeignKey([2]string{"bots", "bot_id"}),
-			column.NewText(
-				column.Source("claimedBy"),
-				column.Dest("claimed_by"),
-				column.NoDefault,
-			),
-			column.NewBool(
-				column.Source("claimed"),
-				column.Dest("claimed"),
-				column.Default(false),
-			),
-			column.NewTimestamp(
-				column.Source("claimedAt"),
-				column.Dest("claimed_at"),
-				"NOW()",
-				transform.ToTimestamp,
-			),
-			column.NewTimestamp(
-				column.Source("unclaimedAt"),
-				column.Dest("unclaimed_at"),
-				"NOW()",
-				transform.ToTimestamp,
-			),
-		),
-	}.Migrate(source, pool)
+	// Always collected, so an interrupted run can show how far it got
+	opts.Report = report.New()
 
-	table.Table{
-		SrcName: "announcements",
-		DstName: "announcements",
-		Columns: column.Columns(
-			/*
-				UserID         string    `bson:"userID" json:"user_id" fkey:"users,user_id"`
-				AnnouncementID string    `bson:"announceID" json:"id" mark:"uuid" defaultfunc:"uuidgen" default:"uuid_generate_v4()" omit:"true"`
-				Title          string    `bson:"title" json:"title"`
-				Content        string    `bson:"content" json:"content"`
-				ModifiedDate   time.Time `bson:"modifiedDate" json:"modified_date" default:"NOW()"`
-				ExpiresDate    time.Time `bson:"expiresDate,omitempty" json:"expires_date" default:"NOW()"`
-				Status         string    `bson:"status" json:"status" default:"'active'"`
-				Targetted      bool      `bson:"targetted" json:"targetted" default:"false"`
-				Target         []string  `bson:"target,omitempty" json:"target" default:"null"`
-			*/
-			column.NewText(
-				column.Source("userID"),
-				column.Dest("user_id"),
-				column.NoDefault,
-			).SetForeignKey([2]string{"users", "user_id"}),
-			column.NewUUID(
-				column.Source("announceID"),
-				column.Dest("id"),
-				"NULL",
-			).SetSQLDefault("uuid_generate_v4()"),
-			column.NewText(
-				column.Source("title"),
-				column.Dest("title"),
-				column.NoDefault,
-			),
-			column.NewText(
-				column.Source("content"),
-				column.Dest("content"),
-				column.NoDefault,
-			),
-			column.NewTimestamp(
-				column.Source("modifiedDate"),
-				column.Dest("modified_date"),
-				"NOW()",
-				transform.ToTimestamp,
-			),
-			column.NewTimestamp(
-				column.Source("expiresDate"),
-				column.Dest("expires_date"),
-				"NOW()",
-			),
-			column.NewText(
-				column.Source("status"),
-				column.Dest("status"),
-				"active",
-			),
-			column.NewBool(
-				column.Source("targetted"),
-				column.Dest("targetted"),
-				false,
-			),
-			column.NewText(
-				column.Source("target"),
-				column.Dest("target"),
-				column.ArrayJSONDefault,
-			).SetArray(true),
-		),
-	}.Migrate(source, pool)
-
-	table.Table{
-		SrcName:       "votes",
-		DstName:       "votes",
-		IgnoreFKError: true,
-		Columns: column.Columns(
-			/*
-							UserID string    `bson:"userID" json:"user_id" fkey:"users,user_id" fkignore:"true"`
-				BotID  string    `bson:"botID" json:"bot_id" fkey:"bots,bot_id"`
-				Date   time.Time `bson:"date" json:"date" default:"NOW()"`
-			*/
-			column.NewText(
-				column.Source("userID"),
-				column.Dest("user_id"),
-				column.NoDefault,
-			).SetForeignKey([2]string{"users", "user_id"}),
-			column.NewText(
-				column.Source("botID"),
-				column.Dest("bot_id"),
-				column.NoDefault,
-			).SetForeignKey([2]string{"bots", "bot_id"}),
-
-			column.NewTimestamp(
-				column.Source("date"),
-				column.Dest("date"),
-				"NOW()",
-				transform.ToTimestamp,
-			),
-		),
-	}.Migrate(source, pool)
+	// Failed runs are the ones the report is most useful for
+	defer func() {
+		if r := recover(); r != nil {
+			err, _ := r.(error)
+			interrupted := errors.Is(err, context.Canceled)
 
-	table.Table{
-		SrcName: "packages",
-		DstName: "packs",
-		Columns: column.Columns(
-			/*
-				Owner   string    `bson:"owner" json:"owner" fkey:"users,user_id"`
-				Name    string    `bson:"name" json:"name" default:"'My pack'"`
-				Short   string    `bson:"short" json:"short"`
-				TagsRaw string    `bson:"tags" json:"tags" tolist:"true"`
-				URL     string    `bson:"url" json:"url" unique:"true"`
-				Date    time.Time `bson:"date" json:"date" default:"NOW()"`
-				Bots    []string  `bson:"bots" json:"bots" tolist:"true"`
-			*/
-			column.NewText(
-				column.Source("owner"),
-				column.Dest("owner"),
-				column.NoDefault,
-			).SetForeignKey([2]string{"users", "user_id"}),
-			column.NewText(
-				column.Source("name"),
-				column.Dest("name"),
-				"My pack",
-			),
-			column.NewText(
-				column.Source("short"),
-				column.Dest("short"),
-				column.NoDefault,
-			),
-			column.NewText(
-				column.Source("tags"),
-				column.Dest("tags"),
-				column.ArrayJSONDefault,
-				transform.ToList,
-			).SetArray(true),
-			column.NewText(
-				column.Source("url"),
-				column.Dest("url"),
-				column.NoDefault,
-			).SetUnique(true),
-			column.NewTimestamp(
-				column.Source("date"),
-				column.Dest("created_at"),
-				"NOW()",
-				transform.ToTimestamp,
-			),
-			column.NewText(
-				column.Source("bots"),
-				column.Dest("bots"),
-				column.ArrayJSONDefault,
-			).SetArray(true),
-		),
-	}.Migrate(source, pool)
-
-	table.Table{
-		SrcName:       "reviews",
-		DstName:       "reviews",
-		IgnoreFKError: true,
-		Columns: column.Columns(
-			/*
-				ID pgtype.UUID `bson:"_id" json:"id" default:"uuid_generate_v4()"`
-				BotID       string         `bson:"botID" json:"bot_id" fkey:"bots,bot_id"`
-				Author      string         `bson:"author" json:"author" fkey:"users,user_id"`
-				Content     string         `bson:"content" json:"content" default:"'Very good bot!'"`
-				StarRate    int            `bson:"star_rate" json:"stars" default:"1"`
-				CreatedAt        time.Time      `bson:"date" json:"created_at" default:"NOW()"`
-				Parent	  string         `bson:"parent" json:"parent" fkey:"reviews,review_id"`
-			*/
-			column.NewUUID(
-				column.Source("id"),
-				column.Dest("id"),
-				"NULL",
-			).SetSQLDefault("uuid_generate_v4()"),
-			column.NewText(
-				column.Source("botID"),
-				column.Dest("bot_id"),
-				column.NoDefault,
-			).SetForeignKey([2]string{"bots", "bot_id"}),
-			column.NewText(
-				column.Source("author"),
-				column.Dest("author"),
-				column.NoDefault,
-			).SetForeignKey([2]string{"users", "user_id"}),
-			column.NewText(
-				column.Source("content"),
-				column.Dest("content"),
-				"Very good bot!",
-			),
-			column.NewInt(
-				column.Source("star_rate"),
-				column.Dest("stars"),
-				5,
-			),
-			column.NewTimestamp(
-				column.Source("date"),
-				column.Dest("created_at"),
-				"NOW()",
-				transform.ToTimestamp,
-			),
-		),
-	}.Migrate(source, pool)
-
-	table.Table{
-		SrcName: "replies",
-		DstName: "replies",
-		Columns: column.Columns(
-			column.NewUUID(
-				column.Source("id"),
-				column.Dest("id"),
-				"NULL",
-			).SetSQLDefault("uuid_generate_v4()"),
-			column.NewText(
-				column.Source("author"),
-				column.Dest("author"),
-				column.NoDefault,
-			).SetForeignKey([2]string{"users", "user_id"}),
-			column.NewText(
-				column.Source("content"),
-				column.Dest("content"),
-				"Very good bot!",
-			),
-			column.NewInt(
-				column.Source("star_rate"),
-				column.Dest("star_rate"),
-				5,
-			),
-			column.NewTimestamp(
-				column.Source("date"),
-				column.Dest("created_at"),
-				"NOW()",
-				transform.ToTimestamp,
-			),
-			column.NewUUID(
-				column.Source("parent"),
-				column.Dest("parent"),
-				column.NoDefault,
-			).SetForeignKey([2]string{"reviews", "id"}),
-		),
-	}.Migrate(source, pool)
-
-	table.Table{
-		SrcName: "tickets",
-		DstName: "tickets",
-		Columns: column.Columns(
-			/*
-				ChannelID      string    `bson:"channelID" json:"channel_id"`
-				Topic          string    `bson:"topic" json:"topic" default:"'Support'"`
-				UserID         string    `bson:"userID" json:"user_id"` // No fkey here bc a user may not be a user on the table yet
-				TicketID       int       `bson:"ticketID" json:"id" unique:"true"`
-				LogURL         string    `bson:"logURL,omitempty" json:"log_url" default:"null"`
-				CloseUserID    string    `bson:"closeUserID,omitempty" json:"close_user_id" default:"null"`
-				Open           bool      `bson:"open" json:"open" default:"true"`
-				Date           time.Time `bson:"date" json:"date" default:"NOW()"`
-				PanelMessageID string    `bson:"panelMessageID,omitempty" json:"panel_message_id" default:"null"`
-				PanelChannelID string    `bson:"panelChannelID,omitempty" json:"panel_channel_id" default:"null"`
-			*/
+			if interrupted {
+				err = errors.New("interrupted")
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
 
-			column.NewText(
-				column.Source("channelID"),
-				column.Dest("channel_id"),
-				column.NoDefault,
-			),
-			column.NewText(
-				column.Source("topic"),
-				column.Dest("topic"),
-				"Support",
-			),
-			column.NewText(
-				column.Source("userID"),
-				column.Dest("user_id"),
-				column.NoDefault,
-			),
-			column.NewInt(
-				column.Source("ticketID"),
-				column.Dest("id"),
-				column.NoDefault,
-			).SetUnique(true),
-			column.NewText(
-				column.Source("logURL"),
-				column.Dest("log_url"),
-				"NULL",
-			).SetNullable(true),
-			column.NewText(
-				column.Source("closeUserID"),
-				column.Dest("close_user_id"),
-				"NULL",
-			).SetNullable(true),
-			column.NewBool(
-				column.Source("open"),
-				column.Dest("open"),
-				true,
-			),
-			column.NewTimestamp(
-				column.Source("date"),
-				column.Dest("created_at"),
-				"NOW()",
-				transform.ToTimestamp,
-			),
-			column.NewText(
-				column.Source("panelMessageID"),
-				column.Dest("panel_message_id"),
-				"NULL",
-			).SetNullable(true),
-			column.NewText(
-				column.Source("panelChannelID"),
-				column.Dest("panel_channel_id"),
-				"NULL",
-			).SetNullable(true),
-		),
-	}.Migrate(source, pool)
-
-	table.Table{
-		SrcName: "transcripts",
-		DstName: "transcripts",
-		Columns: column.Columns(
-		/*
-			TicketID int            `bson:"ticketID" json:"id" fkey:"tickets,id"`
-			Data     map[string]any `bson:"data" json:"data" default:"{}"`
-			ClosedBy map[string]any `bson:"closedBy" json:"closed_by" default:"{}"`
-			OpenedBy map[string]any `bson:"openedBy" json:"opened_by" default:"{}"`
-		*/
-		),
-	}.Migrate(source, pool)
+			if opts.Report != nil {
+				opts.Report.Finish(err)
+				writeReport(opts.Report, *reportJSON, *reportMarkdown, *reportHTML)
+			}
+
+			if !interrupted {
+				panic(r)
+			}
+
+			if opts.Report != nil {
+				opts.Report.WriteMarkdown(os.Stdout)
+			}
+
+			ui.NotifyMsg("warning", "Interrupted, run again with -resume to continue")
+			os.Exit(130)
+		}
+	}()
+
+	// Runs before the report is written above, which exits on interrupts
+	defer func() {
+		if err := discordUsers.Flush(); err != nil {
+			ui.Log.Warn("Could not save enrichment cache", ui.F("path", discordUsers.CachePath), ui.F("error", err))
+		}
+	}()
+
+	if *metricsAddr != "" {
+		opts.Metrics = metrics.New()
+		opts.Metrics.Serve(*metricsAddr)
+
+		ui.NotifyMsg("info", "Serving metrics on "+*metricsAddr+"/metrics")
+	}
+
+	// The tables are created with defaults from uuid-ossp, wherever it lives
+	opts.ExtSchema = table.PrepareTables(ctx, pool, opts)
+	setSearchPath(opts.SearchPath())
+
+	for _, t := range tables {
+		t.Migrate(ctx, src, pool, opts)
+	}
+
+	table.SwapSchemas(ctx, pool, opts)
+
+	// The staging schema no longer exists under its name, the watcher and its transforms use the live one
+	if opts.Swap {
+		setSearchPath(opts.LiveSearchPath())
+		lookups.SetSchema(opts.LiveSchema())
+	}
+
+	if opts.Report != nil {
+		opts.Report.Finish(nil)
+		writeReport(opts.Report, *reportJSON, *reportMarkdown, *reportHTML)
+
+		// Sync is not part of the report
+		opts.Report = nil
+	}
+
+	if *watch {
+		ui.NotifyMsg("info", "Watching for changes, interrupt to stop")
+
+		if err := table.Sync(ctx, src, pool, opts, tables); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Writes the run report in each format a path was given for
+func writeReport(rep *report.Report, jsonPath, markdownPath, htmlPath string) {
+	if jsonPath == "" && markdownPath == "" && htmlPath == "" {
+		return
+	}
+
+	formats := []struct {
+		path  string
+		write func(io.Writer) error
+	}{
+		{jsonPath, rep.WriteJSON},
+		{markdownPath, rep.WriteMarkdown},
+		{htmlPath, rep.WriteHTML},
+	}
+
+	for _, f := range formats {
+		if f.path == "" {
+			continue
+		}
+
+		file, err := os.Create(f.path)
+
+		if err != nil {
+			ui.NotifyMsg("error", "Could not write report: "+err.Error())
+			continue
+		}
+
+		if err := f.write(file); err != nil {
+			ui.NotifyMsg("error", "Could not write report: "+err.Error())
+		}
+
+		file.Close()
+	}
+
+	ui.NotifyMsg("info", "Wrote run report")
 }
 
 // Custom transform helpers
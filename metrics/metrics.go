@@ -0,0 +1,238 @@
+// Package metrics exposes the progress of a migration in the Prometheus text format
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"pouncecat/ui"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Upper bounds (in seconds) of the insert latency histogram buckets
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+type histogram struct {
+	// Cumulative count per bucket, in the order of latencyBuckets
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range latencyBuckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+
+	h.sum += v
+	h.count++
+}
+
+type tableMetrics struct {
+	read            uint64
+	transformed     uint64
+	inserted        uint64
+	rejected        map[string]uint64
+	transformErrors uint64
+	insertLatency   histogram
+}
+
+// Metrics of a run. All methods are safe to call on nil metrics, which collect nothing
+type Metrics struct {
+	mu      sync.Mutex
+	tables  map[string]*tableMetrics
+	current string
+}
+
+func New() *Metrics {
+	return &Metrics{
+		tables: map[string]*tableMetrics{},
+	}
+}
+
+// Must be called with m.mu held
+func (m *Metrics) table(name string) *tableMetrics {
+	t, ok := m.tables[name]
+
+	if !ok {
+		t = &tableMetrics{
+			rejected:      map[string]uint64{},
+			insertLatency: histogram{counts: make([]uint64, len(latencyBuckets))},
+		}
+
+		m.tables[name] = t
+	}
+
+	return t
+}
+
+func (m *Metrics) update(table string, fn func(t *tableMetrics)) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fn(m.table(table))
+}
+
+// Sets the table currently being migrated, empty once done
+func (m *Metrics) SetCurrentTable(table string) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.current = table
+
+	if table != "" {
+		m.table(table)
+	}
+}
+
+func (m *Metrics) RowRead(table string) {
+	m.update(table, func(t *tableMetrics) { t.read++ })
+}
+
+func (m *Metrics) RowTransformed(table string) {
+	m.update(table, func(t *tableMetrics) { t.transformed++ })
+}
+
+func (m *Metrics) RowRejected(table, reason string) {
+	m.update(table, func(t *tableMetrics) { t.rejected[reason]++ })
+}
+
+func (m *Metrics) TransformError(table string) {
+	m.update(table, func(t *tableMetrics) { t.transformErrors++ })
+}
+
+// Records an insert attempt, rows are only counted as inserted if ok is set
+func (m *Metrics) ObserveInsert(table string, took time.Duration, ok bool) {
+	m.update(table, func(t *tableMetrics) {
+		t.insertLatency.observe(took.Seconds())
+
+		if ok {
+			t.inserted++
+		}
+	})
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Writes all metrics in the Prometheus text exposition format
+func (m *Metrics) WriteText(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var names []string
+	for name := range m.tables {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var b strings.Builder
+
+	header := func(name, typ, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	counter := func(name, help string, value func(t *tableMetrics) uint64) {
+		header(name, "counter", help)
+
+		for _, table := range names {
+			fmt.Fprintf(&b, "%s{table=\"%s\"} %d\n", name, escapeLabel(table), value(m.tables[table]))
+		}
+	}
+
+	counter("pouncecat_rows_read_total", "Source records read.", func(t *tableMetrics) uint64 { return t.read })
+	counter("pouncecat_rows_transformed_total", "Source records transformed into rows.", func(t *tableMetrics) uint64 { return t.transformed })
+	counter("pouncecat_rows_inserted_total", "Rows inserted into postgres.", func(t *tableMetrics) uint64 { return t.inserted })
+	counter("pouncecat_transform_errors_total", "Errors raised by transforms.", func(t *tableMetrics) uint64 { return t.transformErrors })
+
+	header("pouncecat_rows_rejected_total", "counter", "Source records not inserted, by reason.")
+
+	for _, table := range names {
+		t := m.tables[table]
+
+		var reasons []string
+		for reason := range t.rejected {
+			reasons = append(reasons, reason)
+		}
+
+		sort.Strings(reasons)
+
+		for _, reason := range reasons {
+			fmt.Fprintf(&b, "pouncecat_rows_rejected_total{table=\"%s\",reason=\"%s\"} %d\n", escapeLabel(table), escapeLabel(reason), t.rejected[reason])
+		}
+	}
+
+	header("pouncecat_insert_duration_seconds", "histogram", "Time taken by single row inserts.")
+
+	for _, table := range names {
+		h := m.tables[table].insertLatency
+		label := escapeLabel(table)
+
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(&b, "pouncecat_insert_duration_seconds_bucket{table=\"%s\",le=\"%s\"} %d\n", label, formatFloat(bound), h.counts[i])
+		}
+
+		fmt.Fprintf(&b, "pouncecat_insert_duration_seconds_bucket{table=\"%s\",le=\"+Inf\"} %d\n", label, h.count)
+		fmt.Fprintf(&b, "pouncecat_insert_duration_seconds_sum{table=\"%s\"} %s\n", label, formatFloat(h.sum))
+		fmt.Fprintf(&b, "pouncecat_insert_duration_seconds_count{table=\"%s\"} %d\n", label, h.count)
+	}
+
+	header("pouncecat_current_table", "gauge", "1 for the table currently being migrated.")
+
+	for _, table := range names {
+		var current int
+
+		if table == m.current {
+			current = 1
+		}
+
+		fmt.Fprintf(&b, "pouncecat_current_table{table=\"%s\"} %d\n", escapeLabel(table), current)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	if err := m.WriteText(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Serves the metrics on /metrics at addr in the background
+func (m *Metrics) Serve(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			ui.Log.Error("Metrics server stopped", ui.F("addr", addr), ui.F("error", err))
+		}
+	}()
+
+	return srv
+}
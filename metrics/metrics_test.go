@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteText(t *testing.T) {
+	m := New()
+
+	m.SetCurrentTable("users")
+	m.RowRead("users")
+	m.RowRead("users")
+	m.RowTransformed("users")
+	m.RowRejected("users", "duplicate")
+	m.RowRejected("users", "default")
+	m.RowRejected("users", "duplicate")
+	m.TransformError(`bots "v2"`)
+	m.ObserveInsert("users", 2*time.Millisecond, true)
+	m.ObserveInsert("users", 3*time.Second, false)
+
+	var b strings.Builder
+
+	if err := m.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	out := b.String()
+
+	for _, line := range []string{
+		"# HELP pouncecat_rows_read_total Source records read.",
+		"# TYPE pouncecat_rows_read_total counter",
+		`pouncecat_rows_read_total{table="users"} 2`,
+		`pouncecat_rows_read_total{table="bots \"v2\""} 0`,
+		`pouncecat_rows_transformed_total{table="users"} 1`,
+		`pouncecat_rows_inserted_total{table="users"} 1`,
+		`pouncecat_transform_errors_total{table="bots \"v2\""} 1`,
+		`pouncecat_rows_rejected_total{table="users",reason="default"} 1`,
+		`pouncecat_rows_rejected_total{table="users",reason="duplicate"} 2`,
+		"# TYPE pouncecat_insert_duration_seconds histogram",
+		`pouncecat_insert_duration_seconds_bucket{table="users",le="0.001"} 0`,
+		`pouncecat_insert_duration_seconds_bucket{table="users",le="0.0025"} 1`,
+		`pouncecat_insert_duration_seconds_bucket{table="users",le="2.5"} 1`,
+		`pouncecat_insert_duration_seconds_bucket{table="users",le="+Inf"} 2`,
+		`pouncecat_insert_duration_seconds_sum{table="users"} 3.002`,
+		`pouncecat_insert_duration_seconds_count{table="users"} 2`,
+		`pouncecat_current_table{table="users"} 1`,
+		`pouncecat_current_table{table="bots \"v2\""} 0`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing line %s in:\n%s", line, out)
+		}
+	}
+
+	// Tables are sorted
+	if strings.Index(out, `pouncecat_rows_read_total{table="bots`) > strings.Index(out, `pouncecat_rows_read_total{table="users"}`) {
+		t.Errorf("tables not sorted:\n%s", out)
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+
+	// Collect nothing instead of panicking
+	m.SetCurrentTable("users")
+	m.RowRead("users")
+	m.RowRejected("users", "duplicate")
+	m.ObserveInsert("users", time.Millisecond, true)
+}
@@ -0,0 +1,175 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+// Skip reasons in the order they are shown in
+var skipReasons = []SkipReason{SkipDefault, SkipFKError, SkipUniqueError, SkipUnresolved, SkipFiltered, SkipDuplicate}
+
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(r)
+}
+
+// Human readable status of the run
+func (r *Report) Status() string {
+	if r.Error != "" {
+		return "failed: " + r.Error
+	}
+
+	return "completed"
+}
+
+// Human readable status of the table
+func (t *Table) Status() string {
+	switch {
+	case t.Error != "":
+		return "failed"
+	case t.AlreadyDone:
+		return "already done"
+	case t.FinishedAt.IsZero():
+		return "incomplete"
+	}
+
+	return "ok"
+}
+
+func (t *Table) sourceCount() string {
+	if t.SourceCount < 0 {
+		return "?"
+	}
+
+	return fmt.Sprint(t.SourceCount)
+}
+
+// Escapes a value for use in a markdown table cell
+func mdCell(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "|", "\\|"), "\n", " ")
+}
+
+func (r *Report) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# Migration report\n\n")
+	fmt.Fprintf(&b, "- Started: %s\n", r.StartedAt.Format("2006-01-02 15:04:05 MST"))
+	fmt.Fprintf(&b, "- Finished: %s\n", r.FinishedAt.Format("2006-01-02 15:04:05 MST"))
+	fmt.Fprintf(&b, "- Duration: %s\n", r.FinishedAt.Sub(r.StartedAt).Round(time.Second))
+	fmt.Fprintf(&b, "- Status: %s\n\n", mdCell(r.Status()))
+
+	fmt.Fprintf(&b, "| Table | Status | Source | Fetched | Inserted |")
+	for _, reason := range skipReasons {
+		fmt.Fprintf(&b, " Skipped (%s) |", reason)
+	}
+	fmt.Fprintf(&b, " Transform errors | Duration (s) | Rows/s |\n|---|---|---|---|---|")
+	for range skipReasons {
+		fmt.Fprintf(&b, "---|")
+	}
+	fmt.Fprintf(&b, "---|---|---|\n")
+
+	for _, t := range r.Tables {
+		fmt.Fprintf(&b, "| %s | %s | %s | %d | %d |", mdCell(t.Name), t.Status(), t.sourceCount(), t.Fetched, t.Inserted)
+		for _, reason := range skipReasons {
+			fmt.Fprintf(&b, " %d |", t.Skipped[reason])
+		}
+		fmt.Fprintf(&b, " %d | %.1f | %.1f |\n", len(t.TransformErrors), t.DurationSeconds, t.RowsPerSecond)
+	}
+
+	for _, t := range r.Tables {
+		if t.Error == "" && len(t.TransformErrors) == 0 && len(t.DDL) == 0 && len(t.Stubs) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&b, "\n## %s\n", t.Name)
+
+		if t.Error != "" {
+			fmt.Fprintf(&b, "\n**Error:** %s\n", mdCell(t.Error))
+		}
+
+		if len(t.TransformErrors) > 0 {
+			fmt.Fprintf(&b, "\n### Transform errors\n\n")
+			for _, msg := range t.TransformErrors {
+				fmt.Fprintf(&b, "- %s\n", mdCell(msg))
+			}
+		}
+
+		if len(t.Stubs) > 0 {
+			fmt.Fprintf(&b, "\n### Stub parents\n\n| Parent | Key |\n|---|---|\n")
+			for _, stub := range t.Stubs {
+				fmt.Fprintf(&b, "| %s | %s |\n", mdCell(stub.Parent), mdCell(stub.Key))
+			}
+		}
+
+		if len(t.DDL) > 0 {
+			fmt.Fprintf(&b, "\n### DDL\n\n```sql\n%s;\n```\n", strings.Join(t.DDL, ";\n"))
+		}
+	}
+
+	if len(r.DDL) > 0 {
+		fmt.Fprintf(&b, "\n## Schema DDL\n\n```sql\n%s;\n```\n", strings.Join(r.DDL, ";\n"))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"skipped": func(t *Table, reason SkipReason) int64 { return t.Skipped[reason] },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Migration report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+.failed { color: #b00; }
+pre { background: #f4f4f4; padding: 1em; overflow-x: auto; }
+</style>
+</head>
+<body>
+<h1>Migration report</h1>
+<ul>
+<li>Started: {{ .Report.StartedAt.Format "2006-01-02 15:04:05 MST" }}</li>
+<li>Finished: {{ .Report.FinishedAt.Format "2006-01-02 15:04:05 MST" }}</li>
+<li>Status: <span{{ if .Report.Error }} class="failed"{{ end }}>{{ .Report.Status }}</span></li>
+</ul>
+<table>
+<tr><th>Table</th><th>Status</th><th>Source</th><th>Fetched</th><th>Inserted</th>{{ range .Reasons }}<th>Skipped ({{ . }})</th>{{ end }}<th>Transform errors</th><th>Duration (s)</th><th>Rows/s</th></tr>
+{{ range $t := .Report.Tables }}<tr{{ if $t.Error }} class="failed"{{ end }}><td>{{ $t.Name }}</td><td>{{ $t.Status }}</td><td>{{ if lt $t.SourceCount 0 }}?{{ else }}{{ $t.SourceCount }}{{ end }}</td><td>{{ $t.Fetched }}</td><td>{{ $t.Inserted }}</td>{{ range $.Reasons }}<td>{{ skipped $t . }}</td>{{ end }}<td>{{ len $t.TransformErrors }}</td><td>{{ printf "%.1f" $t.DurationSeconds }}</td><td>{{ printf "%.1f" $t.RowsPerSecond }}</td></tr>
+{{ end }}</table>
+{{ range .Report.Tables }}{{ if or .Error .TransformErrors .Stubs .DDL }}
+<h2>{{ .Name }}</h2>
+{{ if .Error }}<p class="failed"><b>Error:</b> {{ .Error }}</p>{{ end }}
+{{ if .TransformErrors }}<h3>Transform errors</h3>
+<ul>{{ range .TransformErrors }}<li>{{ . }}</li>{{ end }}</ul>{{ end }}
+{{ if .Stubs }}<h3>Stub parents</h3>
+<table>
+<tr><th>Parent</th><th>Key</th></tr>
+{{ range .Stubs }}<tr><td>{{ .Parent }}</td><td>{{ .Key }}</td></tr>
+{{ end }}</table>{{ end }}
+{{ if .DDL }}<h3>DDL</h3>
+<pre>{{ range .DDL }}{{ . }};
+{{ end }}</pre>{{ end }}
+{{ end }}{{ end }}
+{{ if .Report.DDL }}<h2>Schema DDL</h2>
+<pre>{{ range .Report.DDL }}{{ . }};
+{{ end }}</pre>{{ end }}
+</body>
+</html>
+`))
+
+func (r *Report) WriteHTML(w io.Writer) error {
+	return htmlTemplate.Execute(w, map[string]any{
+		"Report":  r,
+		"Reasons": skipReasons,
+	})
+}
@@ -0,0 +1,202 @@
+// Package report collects what happened during a migration run, so it can be reviewed after the progress bars are gone
+package report
+
+import (
+	"time"
+)
+
+// Why a source record was not inserted
+type SkipReason string
+
+const (
+	// A column fell back to a SKIP default
+	SkipDefault SkipReason = "skip_default"
+	// A foreign key error was ignored (IgnoreFKError)
+	SkipFKError SkipReason = "fk_error"
+	// A unique constraint error was ignored (IgnoreUniqueError)
+	SkipUniqueError SkipReason = "unique_error"
+	// A question needed to fill in a value was answered with skip (or could not be resolved)
+	SkipUnresolved SkipReason = "unresolved"
+	// Dropped by a table filter
+	SkipFiltered SkipReason = "filtered"
+	// Collapsed into another record with the same key (Dedup)
+	SkipDuplicate SkipReason = "duplicate"
+)
+
+// Report of a whole run. All methods are safe to call on a nil report, which collects nothing
+type Report struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// Schema level DDL, such as preparing and swapping schemas
+	DDL    []string `json:"ddl"`
+	Tables []*Table `json:"tables"`
+	// Set if the run did not complete
+	Error string `json:"error,omitempty"`
+}
+
+// Report of a single table. All methods are safe to call on a nil table
+type Table struct {
+	Name string `json:"name"`
+	// Number of records in the source entity, -1 if unknown
+	SourceCount int64 `json:"source_count"`
+	// Number of records fetched by this run, less than SourceCount when resuming or syncing incrementally
+	Fetched  int64                `json:"fetched"`
+	Inserted int64                `json:"inserted"`
+	Skipped  map[SkipReason]int64 `json:"skipped"`
+	// Errors raised by transforms, these abort the migration of the table
+	TransformErrors []string `json:"transform_errors"`
+	DDL             []string `json:"ddl"`
+	// Stub parent rows inserted so foreign keys of the table's rows could be satisfied
+	Stubs []Stub `json:"stubs"`
+	// Whether the table was skipped as a previous run already migrated it
+	AlreadyDone     bool      `json:"already_done"`
+	StartedAt       time.Time `json:"started_at"`
+	FinishedAt      time.Time `json:"finished_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	RowsPerSecond   float64   `json:"rows_per_second"`
+	// Set if the migration of the table failed
+	Error string `json:"error,omitempty"`
+}
+
+// A stub row inserted into a parent table for a missing foreign key
+type Stub struct {
+	Parent string `json:"parent"`
+	Key    string `json:"key"`
+}
+
+func New() *Report {
+	return &Report{
+		StartedAt: time.Now(),
+		DDL:       []string{},
+		Tables:    []*Table{},
+	}
+}
+
+// Starts the report of a table
+func (r *Report) Table(name string) *Table {
+	if r == nil {
+		return nil
+	}
+
+	t := &Table{
+		Name:            name,
+		SourceCount:     -1,
+		Skipped:         map[SkipReason]int64{},
+		TransformErrors: []string{},
+		DDL:             []string{},
+		Stubs:           []Stub{},
+		StartedAt:       time.Now(),
+	}
+
+	r.Tables = append(r.Tables, t)
+
+	return t
+}
+
+func (r *Report) AddDDL(sql string) {
+	if r == nil {
+		return
+	}
+
+	r.DDL = append(r.DDL, sql)
+}
+
+// Marks the run as finished, err should be set if it failed
+func (r *Report) Finish(err error) {
+	if r == nil {
+		return
+	}
+
+	r.FinishedAt = time.Now()
+
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// Total number of skipped records over all reasons
+func (t *Table) SkippedTotal() int64 {
+	if t == nil {
+		return 0
+	}
+
+	var n int64
+
+	for _, c := range t.Skipped {
+		n += c
+	}
+
+	return n
+}
+
+func (t *Table) SetCounts(sourceCount, fetched int64) {
+	if t == nil {
+		return
+	}
+
+	t.SourceCount = sourceCount
+	t.Fetched = fetched
+}
+
+func (t *Table) Insert() {
+	if t == nil {
+		return
+	}
+
+	t.Inserted++
+}
+
+func (t *Table) Skip(reason SkipReason) {
+	if t == nil {
+		return
+	}
+
+	t.Skipped[reason]++
+}
+
+func (t *Table) TransformError(msg string) {
+	if t == nil {
+		return
+	}
+
+	t.TransformErrors = append(t.TransformErrors, msg)
+}
+
+func (t *Table) AddDDL(sql string) {
+	if t == nil {
+		return
+	}
+
+	t.DDL = append(t.DDL, sql)
+}
+
+func (t *Table) AddStub(parent, key string) {
+	if t == nil {
+		return
+	}
+
+	t.Stubs = append(t.Stubs, Stub{Parent: parent, Key: key})
+}
+
+func (t *Table) MarkDone() {
+	if t == nil {
+		return
+	}
+
+	t.AlreadyDone = true
+}
+
+// Marks the table as finished, computing its duration and throughput. msg should be set if it failed
+func (t *Table) Finish(msg string) {
+	if t == nil {
+		return
+	}
+
+	t.FinishedAt = time.Now()
+	t.DurationSeconds = t.FinishedAt.Sub(t.StartedAt).Seconds()
+	t.Error = msg
+
+	if t.DurationSeconds > 0 {
+		t.RowsPerSecond = float64(t.Inserted) / t.DurationSeconds
+	}
+}
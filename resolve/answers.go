@@ -0,0 +1,91 @@
+package resolve
+
+import (
+	"encoding/json"
+	"pouncecat/helpers"
+	"sync"
+	"time"
+)
+
+// An answer recorded in an AnswersFile
+type RecordedAnswer struct {
+	Answer
+	Prompt     string    `json:"prompt"`
+	AnsweredAt time.Time `json:"answered_at"`
+}
+
+// Records answers in a local JSON file keyed by question identity, so re-runs do not ask again
+type AnswersFile struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (f *AnswersFile) load() (map[string]RecordedAnswer, error) {
+	return helpers.ReadJSONMap[RecordedAnswer](f.Path)
+}
+
+func (f *AnswersFile) save(answers map[string]RecordedAnswer) error {
+	bytes, err := json.MarshalIndent(answers, "", "\t")
+
+	if err != nil {
+		return err
+	}
+
+	return helpers.WriteFileAtomic(f.Path, bytes)
+}
+
+// Returns the recorded answer to the question, if any
+func (f *AnswersFile) Get(q *Question) (Answer, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	answers, err := f.load()
+
+	if err != nil {
+		return Answer{}, false, err
+	}
+
+	a, ok := answers[q.Identity()]
+
+	return a.Answer, ok, nil
+}
+
+func (f *AnswersFile) Set(q *Question, a Answer) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	answers, err := f.load()
+
+	if err != nil {
+		return err
+	}
+
+	answers[q.Identity()] = RecordedAnswer{
+		Answer:     a,
+		Prompt:     q.Prompt,
+		AnsweredAt: time.Now(),
+	}
+
+	return f.save(answers)
+}
+
+// Removes the recorded answer to the question
+func (f *AnswersFile) Delete(q *Question) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	answers, err := f.load()
+
+	if err != nil {
+		return err
+	}
+
+	if _, ok := answers[q.Identity()]; !ok {
+		return nil
+	}
+
+	delete(answers, q.Identity())
+
+	return f.save(answers)
+}
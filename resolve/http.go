@@ -0,0 +1,208 @@
+package resolve
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"pouncecat/ui"
+)
+
+// Serves pending questions as a small HTML form on / and a JSON API:
+//
+//	GET  /api/questions  pending questions
+//	POST /api/answer     {"id": "1", "kind": "value", "value": "..."}
+type HTTPFrontend struct {
+	// Address to listen on, such as localhost:34012
+	Addr string
+
+	srv *http.Server
+	// Required by form submissions, so other sites cannot answer through the browser
+	token string
+}
+
+var pageTemplate = template.Must(template.New("questions").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>pouncecat questions</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+.question { border: 1px solid #ccc; padding: 1em; margin-bottom: 1em; }
+pre { background: #f4f4f4; padding: 0.5em; max-height: 20em; overflow: auto; }
+</style>
+</head>
+<body>
+<h1>Pending questions</h1>
+{{ if .Error }}<p style="color: #b00">{{ .Error }}</p>{{ end }}
+{{ range .Questions }}
+<div class="question">
+<h2>#{{ .ID }}: {{ .Prompt }}</h2>
+{{ if .Table }}<p>Table {{ .Table }}, key {{ .Key }}</p>{{ end }}
+{{ if .Record }}<details><summary>Record</summary><pre>{{ printf "%v" .Record }}</pre></details>{{ end }}
+<form method="post" action="/answer">
+<input type="hidden" name="id" value="{{ .ID }}">
+<input type="hidden" name="token" value="{{ $.Token }}">
+{{ range .Candidates }}<button name="value" value="{{ . }}">{{ . }}</button> {{ end }}
+<input name="value" placeholder="Answer">
+<button>Submit</button>
+<button name="kind" value="skip">Skip</button>
+{{ if .AllowDelete }}<button name="kind" value="delete">Delete</button>{{ end }}
+</form>
+</div>
+{{ else }}
+<p>No questions pending.</p>
+{{ end }}
+</body>
+</html>
+`))
+
+// Rejects requests sent by pages of other origins, browsers always set Origin on cross-origin POSTs
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+
+	return err == nil && u.Host == r.Host
+}
+
+func (h *HTTPFrontend) Start(m *Manager) error {
+	token := make([]byte, 16)
+
+	if _, err := rand.Read(token); err != nil {
+		return err
+	}
+
+	h.token = hex.EncodeToString(token)
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		pageTemplate.Execute(w, map[string]any{
+			"Questions": m.Pending(),
+			"Token":     h.token,
+			"Error":     r.URL.Query().Get("error"),
+		})
+	})
+
+	// Form submissions from the page
+	mux.HandleFunc("/answer", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !sameOrigin(r) {
+			http.Error(w, "cross-origin request", http.StatusForbidden)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(r.PostForm.Get("token")), []byte(h.token)) != 1 {
+			http.Error(w, "invalid token, reload the page", http.StatusForbidden)
+			return
+		}
+
+		a := Answer{Kind: AnswerKind(r.PostForm.Get("kind"))}
+
+		if a.Kind == "" {
+			a.Kind = AnswerValue
+
+			// Candidate buttons and the text box share the value name, use whichever was filled in
+			for _, v := range r.PostForm["value"] {
+				if v != "" {
+					a.Value = v
+					break
+				}
+			}
+		}
+
+		if err := m.Answer(r.PostForm.Get("id"), a); err != nil {
+			http.Redirect(w, r, "/?error="+template.URLQueryEscaper(err.Error()), http.StatusSeeOther)
+			return
+		}
+
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	})
+
+	mux.HandleFunc("/api/questions", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(w).Encode(m.Pending()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	mux.HandleFunc("/api/answer", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !sameOrigin(r) {
+			http.Error(w, "cross-origin request", http.StatusForbidden)
+			return
+		}
+
+		var body struct {
+			ID string `json:"id"`
+			Answer
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := m.Answer(body.ID, body.Answer); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// Listen here so a bad address fails the run instead of leaving questions unanswerable
+	ln, err := net.Listen("tcp", h.Addr)
+
+	if err != nil {
+		return err
+	}
+
+	h.srv = &http.Server{Handler: mux}
+
+	go h.srv.Serve(ln)
+
+	ui.NotifyMsg("info", "Answer questions at http://"+ln.Addr().String()+"/")
+
+	return nil
+}
+
+func (h *HTTPFrontend) Notify(q *Question) {
+	ui.Log.Info("Question pending, answer it at http://"+h.Addr+"/", ui.F("id", q.ID), ui.F("question", q.Prompt), ui.F("table", q.Table))
+}
+
+func (h *HTTPFrontend) Close() error {
+	if h.srv == nil {
+		return nil
+	}
+
+	return h.srv.Close()
+}
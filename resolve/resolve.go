@@ -0,0 +1,284 @@
+// Package resolve asks a human for input the migration cannot work out by itself, such as a missing client ID
+package resolve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"pouncecat/ui"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type AnswerKind string
+
+const (
+	// Use Answer.Value
+	AnswerValue AnswerKind = "value"
+	// Skip the record
+	AnswerSkip AnswerKind = "skip"
+	// Delete the record from the source, only accepted if the question allows it
+	AnswerDelete AnswerKind = "delete"
+)
+
+type Answer struct {
+	Kind  AnswerKind `json:"kind"`
+	Value string     `json:"value,omitempty"`
+}
+
+func Value(v string) Answer {
+	return Answer{Kind: AnswerValue, Value: v}
+}
+
+// A question waiting for an answer, along with the context needed to answer it
+type Question struct {
+	ID     string `json:"id"`
+	Prompt string `json:"prompt"`
+	// Stable name of the question (such as client_id), used instead of the prompt to identify recorded answers
+	Name string `json:"name,omitempty"`
+	// Table and source record the question is about
+	Table  string         `json:"table,omitempty"`
+	Key    any            `json:"key,omitempty"`
+	Record map[string]any `json:"record,omitempty"`
+	// Suggested answers
+	Candidates  []string  `json:"candidates,omitempty"`
+	AllowDelete bool      `json:"allow_delete"`
+	AskedAt     time.Time `json:"asked_at"`
+
+	answer chan Answer
+}
+
+// Identifies the question across runs, from the table, record key and name (or prompt)
+func (q *Question) Identity() string {
+	name := q.Name
+
+	if name == "" {
+		name = q.Prompt
+	}
+
+	return q.Table + "/" + fmt.Sprint(q.Key) + "/" + name
+}
+
+// Checks that the answer is acceptable for the question
+func (q *Question) Validate(a Answer) error {
+	switch a.Kind {
+	case AnswerValue:
+		if a.Value == "" {
+			return errors.New("empty answer")
+		}
+	case AnswerSkip:
+	case AnswerDelete:
+		if !q.AllowDelete {
+			return errors.New("deleting is not allowed for this question")
+		}
+	default:
+		return errors.New("unknown answer kind " + string(a.Kind))
+	}
+
+	return nil
+}
+
+// Somewhere questions can be answered
+type Frontend interface {
+	// Starts the frontend, which answers questions through Manager.Answer
+	Start(m *Manager) error
+	// Called when a question is queued
+	Notify(q *Question)
+	Close() error
+}
+
+// Queues questions and hands them to the frontends
+type Manager struct {
+	Frontends []Frontend
+	// Where answers are recorded and reused from, nothing is recorded if nil
+	Answers *AnswersFile
+	// Never ask, questions without a recorded answer get Default or panic if it is nil
+	NonInteractive bool
+	Default        *Answer
+
+	mu      sync.Mutex
+	pending []*Question
+	lastID  int
+}
+
+func NewManager(frontends ...Frontend) *Manager {
+	return &Manager{Frontends: frontends}
+}
+
+// Starts all frontends
+func (m *Manager) Start() error {
+	for _, f := range m.Frontends {
+		if err := f.Start(m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Manager) Close() {
+	for _, f := range m.Frontends {
+		f.Close()
+	}
+}
+
+// Returns the recorded answer to the question, or queues it and blocks until it is answered by any frontend.
+// Panics with the context error if ctx is done first
+func (m *Manager) Ask(ctx context.Context, q *Question) Answer {
+	return m.AskAll(ctx, []*Question{q})[0]
+}
+
+// Like Ask, but queues all questions at once so they can be answered in bulk
+func (m *Manager) AskAll(ctx context.Context, questions []*Question) []Answer {
+	answers := make([]Answer, len(questions))
+
+	var asked []int
+
+	for i, q := range questions {
+		if a, ok := m.recorded(q); ok {
+			answers[i] = a
+			continue
+		}
+
+		if m.NonInteractive {
+			if m.Default == nil {
+				panic("no recorded answer in non-interactive mode for: " + q.Identity())
+			}
+
+			ui.Log.Warn("No recorded answer, using the default", ui.F("question", q.Identity()), ui.F("kind", m.Default.Kind))
+			answers[i] = *m.Default
+			continue
+		}
+
+		if len(m.Frontends) == 0 {
+			panic("no resolution frontends configured, cannot ask: " + q.Prompt)
+		}
+
+		m.mu.Lock()
+		m.lastID++
+		q.ID = strconv.Itoa(m.lastID)
+		q.AskedAt = time.Now()
+		q.answer = make(chan Answer, 1)
+		m.pending = append(m.pending, q)
+		m.mu.Unlock()
+
+		asked = append(asked, i)
+	}
+
+	for _, i := range asked {
+		for _, f := range m.Frontends {
+			f.Notify(questions[i])
+		}
+	}
+
+	for n, i := range asked {
+		q := questions[i]
+
+		select {
+		case answers[i] = <-q.answer:
+		case <-ctx.Done():
+			for _, j := range asked[n:] {
+				m.withdraw(questions[j])
+			}
+
+			panic(ctx.Err())
+		}
+
+		if m.Answers != nil {
+			if err := m.Answers.Set(q, answers[i]); err != nil {
+				ui.Log.Error("Could not record answer", ui.F("question", q.Identity()), ui.F("error", err))
+			}
+		}
+	}
+
+	return answers
+}
+
+func (m *Manager) recorded(q *Question) (Answer, bool) {
+	if m.Answers == nil {
+		return Answer{}, false
+	}
+
+	a, ok, err := m.Answers.Get(q)
+
+	if err != nil {
+		panic(err)
+	}
+
+	if !ok || q.Validate(a) != nil {
+		return Answer{}, false
+	}
+
+	ui.Log.Debug("Using recorded answer", ui.F("question", q.Identity()))
+
+	return a, true
+}
+
+// Forgets the recorded answer to the question, for when it turned out to be wrong. The next Ask asks again
+func (m *Manager) Forget(q *Question) {
+	if m.Answers == nil {
+		return
+	}
+
+	if err := m.Answers.Delete(q); err != nil {
+		ui.Log.Error("Could not forget answer", ui.F("question", q.Identity()), ui.F("error", err))
+	}
+}
+
+// Returns the questions waiting for an answer, oldest first
+func (m *Manager) Pending() []*Question {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]*Question{}, m.pending...)
+}
+
+// Removes a question nobody is waiting on anymore from the pending questions
+func (m *Manager) withdraw(q *Question) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, p := range m.pending {
+		if p == q {
+			m.pending = append(m.pending[:i], m.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// Answers the pending question with the given id
+func (m *Manager) Answer(id string, a Answer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, q := range m.pending {
+		if q.ID != id {
+			continue
+		}
+
+		if err := q.Validate(a); err != nil {
+			return err
+		}
+
+		m.pending = append(m.pending[:i], m.pending[i+1:]...)
+		q.answer <- a
+
+		return nil
+	}
+
+	return errors.New("no pending question with id " + id)
+}
+
+// Returned by a transform (as its last transform) when a value needs a human answer. Migrate holds the row back,
+// inserts the other rows of the table, then asks all questions in bulk and inserts the held rows in a second pass
+type Deferred struct {
+	Question *Question
+	// Turns the answer into the column value, "SKIP" skips the row
+	Resolve func(a Answer) any
+}
+
+// To make things more ergonomic
+func Defer(q *Question, resolve func(a Answer) any) *Deferred {
+	return &Deferred{Question: q, Resolve: resolve}
+}
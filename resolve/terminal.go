@@ -0,0 +1,122 @@
+package resolve
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"pouncecat/ui"
+	"strconv"
+	"strings"
+)
+
+// Asks questions on the terminal. Lines answer the oldest pending question, either with a value,
+// #N to pick the Nth candidate, !skip or !delete
+type TerminalFrontend struct {
+	In io.Reader
+	// Defaults to the progress display writer, so prompts do not garble the bars
+	Out io.Writer
+}
+
+func (t *TerminalFrontend) out() io.Writer {
+	if t.Out == nil {
+		return ui.Reporter.Writer()
+	}
+
+	return t.Out
+}
+
+func (t *TerminalFrontend) Start(m *Manager) error {
+	in := t.In
+
+	if in == nil {
+		in = os.Stdin
+	}
+
+	go func() {
+		scanner := bufio.NewScanner(in)
+
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+
+			if line == "" {
+				continue
+			}
+
+			pending := m.Pending()
+
+			if len(pending) == 0 {
+				fmt.Fprintln(t.out(), "No questions pending")
+				continue
+			}
+
+			q := pending[0]
+
+			a, err := parseAnswer(q, line)
+
+			if err == nil {
+				err = m.Answer(q.ID, a)
+			}
+
+			if err != nil {
+				fmt.Fprintln(t.out(), "Invalid answer:", err)
+				t.Notify(q)
+			}
+		}
+	}()
+
+	return nil
+}
+
+func parseAnswer(q *Question, line string) (Answer, error) {
+	switch {
+	case line == "!skip":
+		return Answer{Kind: AnswerSkip}, nil
+	case line == "!delete":
+		return Answer{Kind: AnswerDelete}, nil
+	case strings.HasPrefix(line, "#"):
+		n, err := strconv.Atoi(line[1:])
+
+		if err != nil || n < 1 || n > len(q.Candidates) {
+			return Answer{}, fmt.Errorf("no candidate %s", line)
+		}
+
+		return Value(q.Candidates[n-1]), nil
+	}
+
+	return Value(line), nil
+}
+
+func (t *TerminalFrontend) Notify(q *Question) {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "\n[question %s] %s\n", q.ID, q.Prompt)
+
+	if q.Table != "" {
+		fmt.Fprintf(&b, "  table: %s, key: %v\n", q.Table, q.Key)
+	}
+
+	for i, c := range q.Candidates {
+		fmt.Fprintf(&b, "  #%d: %s\n", i+1, c)
+	}
+
+	b.WriteString("Type a value")
+
+	if len(q.Candidates) > 0 {
+		b.WriteString(", #N to pick a candidate")
+	}
+
+	b.WriteString(" or !skip")
+
+	if q.AllowDelete {
+		b.WriteString(" or !delete")
+	}
+
+	b.WriteString(":\n")
+
+	io.WriteString(t.out(), b.String())
+}
+
+func (t *TerminalFrontend) Close() error {
+	return nil
+}
@@ -0,0 +1,60 @@
+package resolve
+
+import "testing"
+
+func TestParseAnswer(t *testing.T) {
+	q := &Question{Candidates: []string{"111", "222"}}
+
+	tests := []struct {
+		line    string
+		want    Answer
+		wantErr bool
+	}{
+		{"!skip", Answer{Kind: AnswerSkip}, false},
+		{"!delete", Answer{Kind: AnswerDelete}, false},
+		{"#1", Value("111"), false},
+		{"#2", Value("222"), false},
+		{"#0", Answer{}, true},
+		{"#3", Answer{}, true},
+		{"#x", Answer{}, true},
+		{"#", Answer{}, true},
+		{"333", Value("333"), false},
+		{"!other", Value("!other"), false},
+	}
+
+	for _, tt := range tests {
+		got, err := parseAnswer(q, tt.line)
+
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseAnswer(%q) error = %v, want error %v", tt.line, err, tt.wantErr)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("parseAnswer(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		allowDelete bool
+		answer      Answer
+		ok          bool
+	}{
+		{false, Value("1"), true},
+		{false, Value(""), false},
+		{false, Answer{Kind: AnswerSkip}, true},
+		{false, Answer{Kind: AnswerDelete}, false},
+		{true, Answer{Kind: AnswerDelete}, true},
+		{true, Answer{Kind: "maybe"}, false},
+	}
+
+	for _, tt := range tests {
+		q := &Question{AllowDelete: tt.allowDelete}
+
+		if err := q.Validate(tt.answer); (err == nil) != tt.ok {
+			t.Errorf("Validate(%+v) with delete allowed %v = %v, want ok %v", tt.answer, tt.allowDelete, err, tt.ok)
+		}
+	}
+}
@@ -14,8 +14,6 @@ import (
 	"golang.org/x/exp/slices"
 )
 
-var ctx = context.Background()
-
 type MongoSource struct {
 	ConnectionURL  string
 	DatabaseName   string
@@ -23,9 +21,14 @@ type MongoSource struct {
 	Database       *mongo.Database
 	connected      bool
 	IgnoreEntities []string
+	// File the change stream position is stored in by MarkPosition and Watch
+	ResumeTokenFile string
+	// Whether to request the record before deletion from the change stream, which needs mongo 6.0+ (older servers
+	// reject the option) and changeStreamPreAndPostImages enabled on the collections. Deletes cannot be synced without it
+	PreImages bool
 }
 
-func (m *MongoSource) Connect() error {
+func (m *MongoSource) Connect(ctx context.Context) error {
 	var err error
 	m.Conn, err = mongo.Connect(ctx, options.Client().ApplyURI(m.ConnectionURL))
 	if err != nil {
@@ -36,7 +39,7 @@ func (m *MongoSource) Connect() error {
 	return nil
 }
 
-func (m MongoSource) RecordList() ([]string, error) {
+func (m MongoSource) RecordList(ctx context.Context) ([]string, error) {
 	if !m.connected {
 		return nil, errors.New("not connected")
 	}
@@ -57,7 +60,51 @@ func (m MongoSource) RecordList() ([]string, error) {
 	return record, nil
 }
 
-func (m MongoSource) GetRecords(entity string) ([]map[string]any, error) {
+func (m MongoSource) GetRecords(ctx context.Context, entity string) ([]map[string]any, error) {
+	return m.find(ctx, entity, bson.M{}, options.Find())
+}
+
+func (m MongoSource) DefaultKey() string {
+	return "_id"
+}
+
+func (m MongoSource) GetRecordsAfter(ctx context.Context, entity string, key string, after string) ([]map[string]any, error) {
+	var filter = bson.M{}
+
+	if after != "" {
+		var decoded bson.M
+		if err := bson.UnmarshalExtJSON([]byte(after), true, &decoded); err != nil {
+			return nil, err
+		}
+
+		filter = bson.M{key: bson.M{"$gt": decoded["k"]}}
+	}
+
+	return m.find(ctx, entity, filter, options.Find().SetSort(bson.D{{Key: key, Value: 1}}).SetAllowDiskUse(true))
+}
+
+// The updated field may either be a date or a unix timestamp in milliseconds (see transform.ToTimestamp)
+func (m MongoSource) GetRecordsSince(ctx context.Context, entity string, field string, since time.Time) ([]map[string]any, error) {
+	return m.find(ctx, entity, bson.M{
+		"$or": bson.A{
+			bson.M{field: bson.M{"$gt": primitive.NewDateTimeFromTime(since)}},
+			bson.M{field: bson.M{"$gt": since.UnixMilli()}},
+		},
+	}, options.Find())
+}
+
+// Keys are stored as extended JSON so their type (ObjectID etc.) survives the round trip
+func (m MongoSource) EncodeKey(v any) (string, error) {
+	bytes, err := bson.MarshalExtJSON(bson.M{"k": v}, true, false)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(bytes), nil
+}
+
+func (m MongoSource) find(ctx context.Context, entity string, filter bson.M, opts *options.FindOptions) ([]map[string]any, error) {
 	if slices.Contains(m.IgnoreEntities, entity) {
 		return []map[string]any{}, nil
 	}
@@ -67,7 +114,7 @@ func (m MongoSource) GetRecords(entity string) ([]map[string]any, error) {
 	}
 
 	var record []map[string]any
-	cur, err := m.Database.Collection(entity).Find(ctx, bson.M{})
+	cur, err := m.Database.Collection(entity).Find(ctx, filter, opts)
 
 	if err != nil {
 		return nil, err
@@ -91,7 +138,37 @@ func (m MongoSource) GetRecords(entity string) ([]map[string]any, error) {
 	return record, nil
 }
 
-func (m MongoSource) GetCount(entity string) (int64, error) {
+func (m MongoSource) SampleRecords(ctx context.Context, entity string, n int) ([]map[string]any, error) {
+	if slices.Contains(m.IgnoreEntities, entity) {
+		return []map[string]any{}, nil
+	}
+
+	if !m.connected {
+		return nil, errors.New("not connected")
+	}
+
+	cur, err := m.Database.Collection(entity).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$sample", Value: bson.M{"size": n}}},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var records []bson.M
+	if err := cur.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	var res = make([]map[string]any, len(records))
+	for i, v := range records {
+		res[i] = v
+	}
+
+	return res, nil
+}
+
+func (m MongoSource) GetCount(ctx context.Context, entity string) (int64, error) {
 	if slices.Contains(m.IgnoreEntities, entity) {
 		return 0, nil
 	}
@@ -103,6 +180,24 @@ func (m MongoSource) GetCount(entity string) (int64, error) {
 	return intVal, nil
 }
 
+func (m MongoSource) UpdateRecord(ctx context.Context, entity string, field string, key any, set map[string]any) error {
+	if !m.connected {
+		return errors.New("not connected")
+	}
+
+	_, err := m.Database.Collection(entity).UpdateOne(ctx, bson.M{field: key}, bson.M{"$set": set})
+	return err
+}
+
+func (m MongoSource) DeleteRecord(ctx context.Context, entity string, field string, key any) error {
+	if !m.connected {
+		return errors.New("not connected")
+	}
+
+	_, err := m.Database.Collection(entity).DeleteOne(ctx, bson.M{field: key})
+	return err
+}
+
 // Special mongo specific types
 func (m MongoSource) ExtParse(res any) (any, error) {
 	var result any
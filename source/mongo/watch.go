@@ -0,0 +1,162 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"pouncecat/helpers"
+	"pouncecat/source"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// A change stream event, only the fields we need
+type changeEvent struct {
+	OperationType string `bson:"operationType"`
+	NS            struct {
+		Coll string `bson:"coll"`
+	} `bson:"ns"`
+	DocumentKey              bson.M `bson:"documentKey"`
+	FullDocument             bson.M `bson:"fullDocument"`
+	FullDocumentBeforeChange bson.M `bson:"fullDocumentBeforeChange"`
+}
+
+func (m MongoSource) loadResumeToken() (bson.M, error) {
+	if m.ResumeTokenFile == "" {
+		return nil, errors.New("no resume token file set")
+	}
+
+	bytes, err := os.ReadFile(m.ResumeTokenFile)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var token bson.M
+	err = bson.UnmarshalExtJSON(bytes, false, &token)
+
+	return token, err
+}
+
+func (m MongoSource) saveResumeToken(token bson.Raw) error {
+	bytes, err := bson.MarshalExtJSON(token, false, false)
+
+	if err != nil {
+		return err
+	}
+
+	return helpers.WriteFileAtomic(m.ResumeTokenFile, bytes)
+}
+
+func (m MongoSource) openStream(ctx context.Context, entities []string, token bson.M) (*mongo.ChangeStream, error) {
+	if !m.connected {
+		return nil, errors.New("not connected")
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"ns.coll": bson.M{"$in": entities}}}},
+	}
+
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+
+	if m.PreImages {
+		opts.SetFullDocumentBeforeChange(options.WhenAvailable)
+	}
+
+	if token != nil {
+		opts.SetResumeAfter(token)
+	}
+
+	return m.Database.Watch(ctx, pipeline, opts)
+}
+
+func (m MongoSource) MarkPosition(ctx context.Context, entities []string, keep bool) error {
+	if keep {
+		token, err := m.loadResumeToken()
+
+		if err != nil || token != nil {
+			return err
+		}
+	}
+
+	cs, err := m.openStream(ctx, entities, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer cs.Close(ctx)
+
+	// Set from the (empty) initial batch of the stream
+	if cs.ResumeToken() == nil {
+		return errors.New("change stream did not return a resume token")
+	}
+
+	return m.saveResumeToken(cs.ResumeToken())
+}
+
+// Change streams require a replica set, a single node one (mongod --replSet rs0, then rs.initiate()) is enough locally
+func (m MongoSource) Watch(ctx context.Context, entities []string, fn func(source.Change) error) error {
+	token, err := m.loadResumeToken()
+
+	if err != nil {
+		return err
+	}
+
+	cs, err := m.openStream(ctx, entities, token)
+
+	if err != nil {
+		return err
+	}
+
+	defer cs.Close(context.Background())
+
+	for cs.Next(ctx) {
+		var event changeEvent
+
+		if err := cs.Decode(&event); err != nil {
+			return err
+		}
+
+		change := source.Change{
+			Entity: event.NS.Coll,
+			Key:    event.DocumentKey["_id"],
+		}
+
+		switch event.OperationType {
+		case "insert", "update", "replace":
+			// The document may have been deleted again before the update lookup
+			if event.FullDocument == nil {
+				continue
+			}
+
+			change.Op = source.ChangeOpUpsert
+			change.Record = event.FullDocument
+		case "delete":
+			change.Op = source.ChangeOpDelete
+			change.Record = event.FullDocumentBeforeChange
+		default:
+			// drop, rename, invalidate etc. are not record changes
+			continue
+		}
+
+		if err := fn(change); err != nil {
+			return err
+		}
+
+		if err := m.saveResumeToken(cs.ResumeToken()); err != nil {
+			return err
+		}
+	}
+
+	if errors.Is(cs.Err(), context.Canceled) {
+		return nil
+	}
+
+	return cs.Err()
+}
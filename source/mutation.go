@@ -0,0 +1,137 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"pouncecat/ui"
+	"sync"
+	"time"
+)
+
+// Optionally implemented by sources whose records can be changed, so transforms can write back what they worked out
+type WritableSource interface {
+	Source
+	// Sets fields of the first record of a entity whose field equals key
+	UpdateRecord(ctx context.Context, entity string, field string, key any, set map[string]any) error
+	// Deletes the first record of a entity whose field equals key
+	DeleteRecord(ctx context.Context, entity string, field string, key any) error
+}
+
+type MutationOp string
+
+const (
+	MutationUpdate MutationOp = "update"
+	MutationDelete MutationOp = "delete"
+)
+
+// A change made to a source record during the run
+type Mutation struct {
+	Time   time.Time      `json:"time"`
+	Op     MutationOp     `json:"op"`
+	Entity string         `json:"entity"`
+	Field  string         `json:"field"`
+	Key    any            `json:"key"`
+	Set    map[string]any `json:"set,omitempty"`
+	// Whether the change was only logged and not made
+	DryRun bool   `json:"dry_run"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Wraps a WritableSource, logging every change made through it and only logging them in dry-run mode
+type MutationLog struct {
+	Source WritableSource
+	DryRun bool
+	// File each mutation is appended to as a JSON line, mutations are only logged if empty
+	Path string
+
+	mu sync.Mutex
+}
+
+func (m *MutationLog) GetRecords(ctx context.Context, entity string) ([]map[string]any, error) {
+	return m.Source.GetRecords(ctx, entity)
+}
+
+func (m *MutationLog) GetCount(ctx context.Context, entity string) (int64, error) {
+	return m.Source.GetCount(ctx, entity)
+}
+
+func (m *MutationLog) ExtParse(res any) (any, error) {
+	return m.Source.ExtParse(res)
+}
+
+func (m *MutationLog) RecordList(ctx context.Context) ([]string, error) {
+	return m.Source.RecordList(ctx)
+}
+
+func (m *MutationLog) UpdateRecord(ctx context.Context, entity string, field string, key any, set map[string]any) error {
+	return m.apply(Mutation{Op: MutationUpdate, Entity: entity, Field: field, Key: key, Set: set}, func() error {
+		return m.Source.UpdateRecord(ctx, entity, field, key, set)
+	})
+}
+
+func (m *MutationLog) DeleteRecord(ctx context.Context, entity string, field string, key any) error {
+	return m.apply(Mutation{Op: MutationDelete, Entity: entity, Field: field, Key: key}, func() error {
+		return m.Source.DeleteRecord(ctx, entity, field, key)
+	})
+}
+
+func (m *MutationLog) apply(mut Mutation, fn func() error) error {
+	mut.Time = time.Now()
+	mut.DryRun = m.DryRun
+
+	var err error
+
+	if !m.DryRun {
+		err = fn()
+	}
+
+	if err != nil {
+		mut.Error = err.Error()
+	}
+
+	fields := []ui.Field{ui.F("op", mut.Op), ui.F("entity", mut.Entity), ui.F(mut.Field, mut.Key), ui.F("dry_run", mut.DryRun)}
+
+	if mut.Set != nil {
+		fields = append(fields, ui.F("set", mut.Set))
+	}
+
+	if err != nil {
+		ui.Log.Error("Source mutation failed", append(fields, ui.F("error", err))...)
+	} else {
+		ui.Log.Info("Source mutation", fields...)
+	}
+
+	if werr := m.write(mut); werr != nil {
+		ui.Log.Error("Could not write source mutation log", ui.F("path", m.Path), ui.F("error", werr))
+	}
+
+	return err
+}
+
+func (m *MutationLog) write(mut Mutation) error {
+	if m.Path == "" {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bytes, err := json.Marshal(mut)
+
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	_, err = f.Write(append(bytes, '\n'))
+
+	return err
+}
@@ -1,12 +1,71 @@
 package source
 
+import (
+	"context"
+	"time"
+)
+
 type Source interface {
 	// Returns the records of a entity (collection in mongo, row in postgres etc)
-	GetRecords(entity string) ([]map[string]any, error)
+	GetRecords(ctx context.Context, entity string) ([]map[string]any, error)
 	// Gets the count of records in a entity
-	GetCount(entity string) (int64, error)
+	GetCount(ctx context.Context, entity string) (int64, error)
 	// Extra parsers
 	ExtParse(res any) (any, error)
 	// Fetches all table/collection names
-	RecordList() ([]string, error)
+	RecordList(ctx context.Context) ([]string, error)
+}
+
+// Optionally implemented by sources that can return records in a stable key order, allowing partially migrated tables to be resumed
+type KeyedSource interface {
+	Source
+	// The field records are keyed by when a table does not set one (_id in mongo)
+	DefaultKey() string
+	// Returns the records of a entity ordered by key, starting after the given encoded key (all records if empty)
+	GetRecordsAfter(ctx context.Context, entity string, key string, after string) ([]map[string]any, error)
+	// Encodes a key value so it can be stored in a checkpoint
+	EncodeKey(v any) (string, error)
+}
+
+// Optionally implemented by sources that can return only the records changed since a given time
+type IncrementalSource interface {
+	Source
+	// Returns the records of a entity whose updated field is after since
+	GetRecordsSince(ctx context.Context, entity string, field string, since time.Time) ([]map[string]any, error)
+}
+
+type ChangeOp int
+
+const (
+	// Record was inserted, updated or replaced, Record holds the full record
+	ChangeOpUpsert ChangeOp = iota
+	// Record was deleted, Record holds the record before deletion if the source could provide it
+	ChangeOpDelete
+)
+
+// A single change to a record of a entity
+type Change struct {
+	Op     ChangeOp
+	Entity string
+	// Key of the changed record (_id in mongo)
+	Key    any
+	Record map[string]any
+}
+
+// Optionally implemented by sources that can stream changes made after a bulk migration
+type WatchableSource interface {
+	Source
+	// Stores the current position of the change stream so a later Watch starts from it. If keep is set, an already stored
+	// position is kept instead, for resumed runs. Should be called before the bulk migration so changes made during it are not lost
+	MarkPosition(ctx context.Context, entities []string, keep bool) error
+	// Calls fn for each change to the entities after the stored position, until ctx is cancelled or fn fails.
+	// The position is stored after each change fn handled successfully
+	Watch(ctx context.Context, entities []string, fn func(Change) error) error
+}
+
+// Optionally implemented by sources that can efficiently return a random sample of records
+type SamplingSource interface {
+	Source
+	// Returns up to n random records of a entity
+	SampleRecords(ctx context.Context, entity string, n int) ([]map[string]any, error)
 }
@@ -0,0 +1,224 @@
+package table
+
+import (
+	"context"
+	"errors"
+	"pouncecat/helpers"
+	"pouncecat/resolve"
+	"pouncecat/source"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"golang.org/x/exp/slices"
+)
+
+// Number of rows loaded per batch when checksumming
+const checksumBatchSize = 1000
+
+// A key ordered range of rows whose checksums were compared between the source and the destination
+type ChecksumBucket struct {
+	Bucket int `json:"bucket"`
+	// First and last key in the bucket (from either side), as postgres formats them
+	FirstKey   string `json:"first_key"`
+	LastKey    string `json:"last_key"`
+	SourceRows int64  `json:"source_rows"`
+	DestRows   int64  `json:"dest_rows"`
+	SourceSum  string `json:"source_sum"`
+	DestSum    string `json:"dest_sum"`
+}
+
+func (b ChecksumBucket) OK() bool {
+	return b.SourceRows == b.DestRows && b.SourceSum == b.DestSum
+}
+
+// The columns hashed by Checksum, in the order of Columns. Volatile columns are left out unless part of the key
+func (t Table) checksumColumns(key []string) []string {
+	var cols []string
+
+	for _, col := range t.Columns {
+		if !col.Volatile || slices.Contains(key, col.DstName) {
+			cols = append(cols, col.DstName)
+		}
+	}
+
+	return cols
+}
+
+// Computes per-bucket checksums of the transformed source records and the migrated rows, with rows split into
+// (at most) buckets key ordered ranges so differences can be narrowed down to a range of keys.
+//
+// The transformed records are loaded into a temporary copy of the hashed columns so both sides are hashed by postgres
+// from the same column types, otherwise differences in formatting (timestamps, arrays etc.) would show up as mismatches
+func (t Table) Checksum(ctx context.Context, src source.Source, pool *pgxpool.Pool, opts Options, buckets int) ([]ChecksumBucket, error) {
+	key := t.rowKey()
+
+	if len(key) == 0 {
+		return nil, errors.New("no conflict key or primary key columns to order rows by")
+	}
+
+	cols := t.checksumColumns(key)
+
+	records, err := src.GetRecords(ctx, t.SrcName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	records = selectedRecords(t.selectRecords(src, records, nil))
+
+	tx, err := pool.Begin(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	tableName := helpers.QuoteIdent(opts.schema(), t.DstName)
+
+	// Only the hashed columns are copied, so NOT NULL and other constraints of the columns left out cannot fail inserts
+	_, err = tx.Exec(ctx, "CREATE TEMP TABLE "+helpers.QuoteIdent(t.DstName)+" ON COMMIT DROP AS SELECT "+helpers.QuoteIdents(cols)+" FROM "+tableName+" WITH NO DATA")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := copyDefaults(ctx, tx, opts.schema(), t.DstName, cols); err != nil {
+		return nil, err
+	}
+
+	batch := &pgx.Batch{}
+
+	for i, record := range records {
+		// Transforms of the columns left out do not run, so rows skipped by them are still compared
+		colNames, args, skip := t.parseColumns(t.transformContext(ctx, src, pool, nil, i), record, cols)
+
+		if skip {
+			continue
+		}
+
+		var names []string
+		var values []any
+
+		for n, colName := range colNames {
+			// Left to its default, values waiting for an answer cannot be compared
+			if _, ok := args[n].(*resolve.Deferred); ok {
+				continue
+			}
+
+			names = append(names, colName)
+			values = append(values, args[n])
+		}
+
+		batch.Queue(t.insertSQL("pg_temp", names, false), values...)
+
+		if batch.Len() >= checksumBatchSize {
+			if err := sendBatch(ctx, tx, batch); err != nil {
+				return nil, err
+			}
+
+			batch = &pgx.Batch{}
+		}
+	}
+
+	if err := sendBatch(ctx, tx, batch); err != nil {
+		return nil, err
+	}
+
+	keyCols := helpers.QuoteIdents(key)
+	rowSum := "md5(ROW(" + helpers.QuoteIdents(cols) + ")::text)"
+
+	keyText := helpers.QuoteIdent(key[0]) + "::text"
+	if len(key) > 1 {
+		keyText = "ROW(" + keyCols + ")::text"
+	}
+
+	rows, err := tx.Query(ctx, `WITH src AS (SELECT `+keyCols+`, `+rowSum+` AS row_sum FROM `+helpers.QuoteIdent("pg_temp", t.DstName)+`),
+	dst AS (SELECT `+keyCols+`, `+rowSum+` AS row_sum FROM `+tableName+`),
+	keys AS (SELECT `+keyCols+` FROM src UNION SELECT `+keyCols+` FROM dst),
+	bucketed AS (SELECT `+keyCols+`, `+keyText+` AS key_text, ntile($1) OVER (ORDER BY `+keyCols+`) AS bucket FROM keys)
+	SELECT bucket,
+		COALESCE((array_agg(key_text ORDER BY `+keyCols+`))[1], ''),
+		COALESCE((array_agg(key_text ORDER BY `+strings.ReplaceAll(keyCols, ",", " DESC,")+` DESC))[1], ''),
+		count(src.row_sum), count(dst.row_sum),
+		COALESCE(md5(string_agg(src.row_sum, '' ORDER BY `+keyCols+`)), ''),
+		COALESCE(md5(string_agg(dst.row_sum, '' ORDER BY `+keyCols+`)), '')
+	FROM bucketed LEFT JOIN src USING (`+keyCols+`) LEFT JOIN dst USING (`+keyCols+`)
+	GROUP BY bucket ORDER BY bucket`, buckets)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var res []ChecksumBucket
+
+	for rows.Next() {
+		var b ChecksumBucket
+
+		if err := rows.Scan(&b.Bucket, &b.FirstKey, &b.LastKey, &b.SourceRows, &b.DestRows, &b.SourceSum, &b.DestSum); err != nil {
+			return nil, err
+		}
+
+		res = append(res, b)
+	}
+
+	return res, rows.Err()
+}
+
+// Copies the defaults of cols from a table to its temporary copy, for the columns left to their defaults
+func copyDefaults(ctx context.Context, tx pgx.Tx, schema, table string, cols []string) error {
+	rows, err := tx.Query(ctx, "SELECT column_name, column_default FROM information_schema.columns WHERE table_schema = $1 AND table_name = $2 AND column_default IS NOT NULL", schema, table)
+
+	if err != nil {
+		return err
+	}
+
+	defaults := map[string]string{}
+
+	for rows.Next() {
+		var name, def string
+
+		if err := rows.Scan(&name, &def); err != nil {
+			rows.Close()
+			return err
+		}
+
+		if slices.Contains(cols, name) {
+			defaults[name] = def
+		}
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for name, def := range defaults {
+		if _, err := tx.Exec(ctx, "ALTER TABLE "+helpers.QuoteIdent("pg_temp", table)+" ALTER COLUMN "+helpers.QuoteIdent(name)+" SET DEFAULT "+def); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func sendBatch(ctx context.Context, tx pgx.Tx, batch *pgx.Batch) error {
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	br := tx.SendBatch(ctx, batch)
+
+	for i := 0; i < batch.Len(); i++ {
+		if _, err := br.Exec(); err != nil {
+			br.Close()
+			return err
+		}
+	}
+
+	return br.Close()
+}
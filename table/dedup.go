@@ -0,0 +1,209 @@
+package table
+
+import (
+	"fmt"
+	"pouncecat/source"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type DedupStrategy string
+
+const (
+	// Keep the first record in source order
+	DedupFirst DedupStrategy = "first"
+	// Keep the last record in source order
+	DedupLast DedupStrategy = "last"
+	// Keep the record with the latest Dedup.TimeField, records without one lose
+	DedupNewest DedupStrategy = "newest"
+	// Merge all records into the first, array fields are combined (without repeats) and missing fields filled in
+	DedupMerge DedupStrategy = "merge"
+)
+
+// Collapses source records sharing a key into one, before transforms run. Duplicates are only found among the records
+// fetched by a run, so resuming or incrementally syncing a table can still run into rows inserted earlier
+type Dedup struct {
+	// Source fields identifying duplicates
+	Key      []string
+	Strategy DedupStrategy
+	// Source field holding the modification time, for DedupNewest
+	TimeField string
+	// Turns a key field value into the string compared, defaults to fmt.Sprint
+	Normalize func(v any) string
+}
+
+func (d *Dedup) Validate() error {
+	if len(d.Key) == 0 {
+		return fmt.Errorf("dedup: no key")
+	}
+
+	switch d.Strategy {
+	case DedupFirst, DedupLast, DedupMerge:
+	case DedupNewest:
+		if d.TimeField == "" {
+			return fmt.Errorf("dedup: newest needs a time field")
+		}
+	default:
+		return fmt.Errorf("dedup: unknown strategy %q", d.Strategy)
+	}
+
+	return nil
+}
+
+func (d *Dedup) key(record map[string]any) string {
+	parts := make([]string, len(d.Key))
+
+	for i, field := range d.Key {
+		if d.Normalize != nil {
+			parts[i] = d.Normalize(record[field])
+		} else {
+			parts[i] = fmt.Sprint(record[field])
+		}
+	}
+
+	return strings.Join(parts, "\x00")
+}
+
+// Returns the records with duplicates collapsed and the number of records dropped. Each kept record takes the
+// place of the last record of its group and is checkpointed by its key, so checkpoints never move backwards or
+// past a duplicate that has not been seen yet
+func (d *Dedup) apply(src source.Source, records []selectedRecord) ([]selectedRecord, int) {
+	groups := map[string][]map[string]any{}
+	last := map[string]int{}
+
+	for i, sel := range records {
+		k := d.key(sel.Record)
+		groups[k] = append(groups[k], sel.Record)
+		last[k] = i
+	}
+
+	var kept []selectedRecord
+
+	for i, sel := range records {
+		k := d.key(sel.Record)
+
+		if last[k] != i {
+			continue
+		}
+
+		kept = append(kept, selectedRecord{Record: d.pick(src, groups[k]), At: sel.At})
+	}
+
+	return kept, len(records) - len(kept)
+}
+
+func (d *Dedup) pick(src source.Source, group []map[string]any) map[string]any {
+	if len(group) == 1 {
+		return group[0]
+	}
+
+	switch d.Strategy {
+	case DedupLast:
+		return group[len(group)-1]
+	case DedupNewest:
+		newest := group[0]
+		newestAt, _ := recordTime(src, newest[d.TimeField])
+
+		for _, record := range group[1:] {
+			if at, ok := recordTime(src, record[d.TimeField]); ok && at.After(newestAt) {
+				newest, newestAt = record, at
+			}
+		}
+
+		return newest
+	case DedupMerge:
+		merged := map[string]any{}
+
+		for k, v := range group[0] {
+			merged[k] = v
+		}
+
+		for _, record := range group[1:] {
+			for k, v := range record {
+				merged[k] = mergeValue(merged[k], v)
+			}
+		}
+
+		return merged
+	}
+
+	return group[0]
+}
+
+// Combines two values of a field, arrays are joined (keeping the type of the first, such as primitive.A) and anything
+// else keeps the first non-nil value
+func mergeValue(a, b any) any {
+	if a == nil {
+		return b
+	}
+
+	av, bv := reflect.ValueOf(a), reflect.ValueOf(b)
+
+	if av.Kind() != reflect.Slice || b == nil || bv.Kind() != reflect.Slice {
+		return a
+	}
+
+	elem := av.Type().Elem()
+	res := reflect.MakeSlice(av.Type(), 0, av.Len()+bv.Len())
+	seen := map[string]bool{}
+
+	for _, v := range []reflect.Value{av, bv} {
+		for i := 0; i < v.Len(); i++ {
+			item := v.Index(i)
+
+			if item.Kind() == reflect.Interface {
+				if item.IsNil() {
+					item = reflect.Zero(elem)
+				} else {
+					item = item.Elem()
+				}
+			}
+
+			// Arrays of different types cannot be joined without changing the type
+			if !item.Type().AssignableTo(elem) {
+				return a
+			}
+
+			k := fmt.Sprint(item.Interface())
+
+			if seen[k] {
+				continue
+			}
+
+			seen[k] = true
+			res = reflect.Append(res, item)
+		}
+	}
+
+	return res.Interface()
+}
+
+// Reads a timestamp the way transform.ToTimestamp does, returning false if there is none
+func recordTime(src source.Source, v any) (time.Time, bool) {
+	if parsed, err := src.ExtParse(v); err == nil {
+		v = parsed
+	}
+
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case int64:
+		return time.UnixMilli(t), true
+	case int32:
+		return time.UnixMilli(int64(t)), true
+	case float64:
+		return time.UnixMilli(int64(t)), true
+	case string:
+		if ms, err := strconv.ParseInt(t, 10, 64); err == nil {
+			return time.UnixMilli(ms), true
+		}
+
+		if at, err := time.Parse(time.RFC3339, t); err == nil {
+			return at, true
+		}
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,213 @@
+package table
+
+import (
+	"errors"
+	"pouncecat/source"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Only ExtParse is used by dedup, which parses nothing
+type plainSource struct {
+	source.Source
+}
+
+func (plainSource) ExtParse(v any) (any, error) {
+	return nil, errors.New("not an extended type")
+}
+
+func selected(records ...map[string]any) []selectedRecord {
+	res := make([]selectedRecord, len(records))
+
+	for i, record := range records {
+		res[i] = selectedRecord{Record: record, At: record}
+	}
+
+	return res
+}
+
+func TestDedupApply(t *testing.T) {
+	a1 := map[string]any{"id": "a1", "k": "x", "at": int64(200)}
+	b1 := map[string]any{"id": "b1", "k": "y"}
+	a2 := map[string]any{"id": "a2", "k": "x", "at": int64(100)}
+	a3 := map[string]any{"id": "a3", "k": " x ", "at": int64(300)}
+
+	tests := []struct {
+		name    string
+		dedup   Dedup
+		records []selectedRecord
+		// Ids of the kept records and of the records they are checkpointed by
+		want    []string
+		wantAt  []string
+		dropped int
+	}{
+		{
+			name:    "no duplicates",
+			dedup:   Dedup{Key: []string{"k"}, Strategy: DedupFirst},
+			records: selected(a1, b1),
+			want:    []string{"a1", "b1"},
+			wantAt:  []string{"a1", "b1"},
+		},
+		{
+			name:    "first",
+			dedup:   Dedup{Key: []string{"k"}, Strategy: DedupFirst},
+			records: selected(a1, b1, a2),
+			want:    []string{"b1", "a1"},
+			wantAt:  []string{"b1", "a2"},
+			dropped: 1,
+		},
+		{
+			name:    "last",
+			dedup:   Dedup{Key: []string{"k"}, Strategy: DedupLast},
+			records: selected(a1, b1, a2),
+			want:    []string{"b1", "a2"},
+			wantAt:  []string{"b1", "a2"},
+			dropped: 1,
+		},
+		{
+			name:    "newest",
+			dedup:   Dedup{Key: []string{"k"}, Strategy: DedupNewest, TimeField: "at"},
+			records: selected(a1, b1, a2),
+			want:    []string{"b1", "a1"},
+			wantAt:  []string{"b1", "a2"},
+			dropped: 1,
+		},
+		{
+			name:    "newest without a time loses",
+			dedup:   Dedup{Key: []string{"k"}, Strategy: DedupNewest, TimeField: "at"},
+			records: selected(map[string]any{"id": "c1", "k": "z"}, map[string]any{"id": "c2", "k": "z", "at": int64(1)}),
+			want:    []string{"c2"},
+			wantAt:  []string{"c2"},
+			dropped: 1,
+		},
+		{
+			name:    "not normalized",
+			dedup:   Dedup{Key: []string{"k"}, Strategy: DedupFirst},
+			records: selected(a1, a3),
+			want:    []string{"a1", "a3"},
+			wantAt:  []string{"a1", "a3"},
+		},
+		{
+			name:    "normalized",
+			dedup:   Dedup{Key: []string{"k"}, Strategy: DedupLast, Normalize: func(v any) string { return strings.TrimSpace(v.(string)) }},
+			records: selected(a1, a3),
+			want:    []string{"a3"},
+			wantAt:  []string{"a3"},
+			dropped: 1,
+		},
+		{
+			name:    "multiple key fields",
+			dedup:   Dedup{Key: []string{"k", "at"}, Strategy: DedupFirst},
+			records: selected(a1, a2, map[string]any{"id": "a4", "k": "x", "at": int64(200)}),
+			want:    []string{"a2", "a1"},
+			wantAt:  []string{"a2", "a4"},
+			dropped: 1,
+		},
+		{
+			name:    "checkpointed by the last record of the group",
+			dedup:   Dedup{Key: []string{"k"}, Strategy: DedupFirst},
+			records: []selectedRecord{{Record: a1, At: b1}, {Record: a2, At: a2}},
+			want:    []string{"a1"},
+			wantAt:  []string{"a2"},
+			dropped: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, dropped := tt.dedup.apply(plainSource{}, tt.records)
+
+			var got, gotAt []string
+			for _, sel := range kept {
+				got = append(got, sel.Record["id"].(string))
+				gotAt = append(gotAt, sel.At["id"].(string))
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("kept %v, want %v", got, tt.want)
+			}
+
+			if !reflect.DeepEqual(gotAt, tt.wantAt) {
+				t.Errorf("checkpointed by %v, want %v", gotAt, tt.wantAt)
+			}
+
+			if dropped != tt.dropped {
+				t.Errorf("dropped %d, want %d", dropped, tt.dropped)
+			}
+		})
+	}
+}
+
+func TestDedupPickMerge(t *testing.T) {
+	d := Dedup{Key: []string{"k"}, Strategy: DedupMerge}
+
+	group := []map[string]any{
+		{"k": "x", "name": "first", "tags": primitive.A{"a", "b"}, "bio": nil},
+		{"k": "x", "name": "second", "tags": primitive.A{"b", "c"}, "bio": "hello", "extra": 1},
+	}
+
+	got := d.pick(plainSource{}, group)
+
+	want := map[string]any{"k": "x", "name": "first", "tags": primitive.A{"a", "b", "c"}, "bio": "hello", "extra": 1}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("merged %v, want %v", got, want)
+	}
+
+	// The group itself is left alone
+	if !reflect.DeepEqual(group[0]["tags"], primitive.A{"a", "b"}) {
+		t.Errorf("first record changed to %v", group[0])
+	}
+}
+
+func TestMergeValue(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b any
+		want any
+	}{
+		{"nil first", nil, "b", "b"},
+		{"keeps first", "a", "b", "a"},
+		{"keeps first over nil", "a", nil, "a"},
+		{"array and scalar", primitive.A{"a"}, "b", primitive.A{"a"}},
+		{"keeps array type", primitive.A{"a", "b"}, primitive.A{"b", "c"}, primitive.A{"a", "b", "c"}},
+		{"typed slices", []string{"a"}, []string{"a", "b"}, []string{"a", "b"}},
+		{"untyped items into typed slice", []string{"a"}, primitive.A{"b"}, []string{"a", "b"}},
+		{"repeats within one array", primitive.A{"a", "a"}, primitive.A{}, primitive.A{"a"}},
+		{"nil items", primitive.A{nil}, primitive.A{"a", nil}, primitive.A{nil, "a"}},
+		{"incompatible items", []string{"a"}, primitive.A{1}, []string{"a"}},
+		{"incompatible slices", []int{1}, []string{"a"}, []int{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mergeValue(tt.a, tt.b)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeValue(%#v, %#v) = %#v, want %#v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDedupValidate(t *testing.T) {
+	tests := []struct {
+		dedup Dedup
+		ok    bool
+	}{
+		{Dedup{Key: []string{"k"}, Strategy: DedupFirst}, true},
+		{Dedup{Key: []string{"k"}, Strategy: DedupNewest, TimeField: "at"}, true},
+		{Dedup{Strategy: DedupFirst}, false},
+		{Dedup{Key: []string{"k"}, Strategy: DedupNewest}, false},
+		{Dedup{Key: []string{"k"}, Strategy: "random"}, false},
+	}
+
+	for _, tt := range tests {
+		if err := tt.dedup.Validate(); (err == nil) != tt.ok {
+			t.Errorf("Validate(%+v) = %v, want ok %v", tt.dedup, err, tt.ok)
+		}
+	}
+}
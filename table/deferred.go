@@ -0,0 +1,65 @@
+package table
+
+import (
+	"context"
+	"pouncecat/resolve"
+	"pouncecat/ui"
+)
+
+func hasDeferred(args []any) bool {
+	for _, arg := range args {
+		if _, ok := arg.(*resolve.Deferred); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Asks the questions of all held rows in bulk and fills in their answers, returning whether each row can now be
+// inserted. Rows whose answers resolve to SKIP cannot
+func (t Table) resolveHeld(ctx context.Context, opts Options, held []parsedDataStruct) []bool {
+	var questions []*resolve.Question
+
+	for _, row := range held {
+		for _, arg := range row.Args {
+			if d, ok := arg.(*resolve.Deferred); ok {
+				questions = append(questions, d.Question)
+			}
+		}
+	}
+
+	if opts.Resolver == nil {
+		panic("transforms of " + t.DstName + " need answers to questions, but no resolver is configured")
+	}
+
+	ui.Log.Info("Asking pending questions", ui.F("table", t.DstName), ui.F("questions", len(questions)), ui.F("rows", len(held)))
+
+	answers := opts.Resolver.AskAll(ctx, questions)
+
+	ready := make([]bool, len(held))
+	var n int
+
+	for r, row := range held {
+		var skip bool
+
+		for i, arg := range row.Args {
+			d, ok := arg.(*resolve.Deferred)
+
+			if !ok {
+				continue
+			}
+
+			row.Args[i] = d.Resolve(answers[n])
+			n++
+
+			if row.Args[i] == "SKIP" {
+				skip = true
+			}
+		}
+
+		ready[r] = !skip
+	}
+
+	return ready
+}
@@ -0,0 +1,420 @@
+package table
+
+import (
+	"context"
+	"pouncecat/column"
+	"pouncecat/helpers"
+	"pouncecat/report"
+	"pouncecat/ui"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/exp/slices"
+)
+
+// Postgres reports some types by their full name (format_type), this maps our names to those
+var canonicalTypes = map[string]string{
+	"int":         "integer",
+	"bool":        "boolean",
+	"timestamptz": "timestamp with time zone",
+}
+
+// Matches casts such as ::text or ::timestamp with time zone[] in a default expression
+var castRegex = regexp.MustCompile(`::[a-z ]+(\[\])?`)
+
+type existingColumn struct {
+	Type     string
+	Nullable bool
+	Default  string
+}
+
+type existingConstraint struct {
+	// contype, such as p or f
+	Type string
+	// As returned by pg_get_constraintdef
+	Def string
+}
+
+// The current state of a table in the database
+type existingTable struct {
+	Columns     map[string]existingColumn
+	Constraints map[string]existingConstraint
+	// Index definitions (pg_get_indexdef) by name
+	Indexes    map[string]string
+	PrimaryKey []string
+}
+
+// A single schema change computed by diffing a table against the database
+type schemaChange struct {
+	Desc        string
+	SQL         string
+	Destructive bool
+}
+
+func canonicalType(col *column.Column) string {
+	base := col.BaseType()
+
+	if name, ok := canonicalTypes[base]; ok {
+		base = name
+	}
+
+	if col.Array {
+		return base + "[]"
+	}
+
+	return base
+}
+
+// Normalizes a default expression so ours and the one postgres reports can be compared
+func normalizeDefault(def string) string {
+	def = castRegex.ReplaceAllString(def, "")
+
+	if !strings.Contains(def, "'") {
+		def = strings.ToLower(def)
+	}
+
+	if def == "null" {
+		return ""
+	}
+
+	return def
+}
+
+// Normalizes a constraint or index definition so ours and the one postgres reports can be compared. Postgres drops
+// quotes, schemas on the search path and the default index method and adds parentheses and casts, so all of those
+// are left out, as is whitespace. Case only matters in string literals
+func normalizeDef(def, schema string) string {
+	def = castRegex.ReplaceAllString(def, "")
+	def = strings.ReplaceAll(def, `"`, "")
+	def = strings.ReplaceAll(def, schema+".", "")
+
+	var b strings.Builder
+	var inString bool
+
+	for _, r := range def {
+		if r == '\'' {
+			inString = !inString
+		}
+
+		if !inString && (unicode.IsSpace(r) || r == '(' || r == ')') {
+			continue
+		}
+
+		if !inString {
+			r = unicode.ToLower(r)
+		}
+
+		b.WriteRune(r)
+	}
+
+	return strings.Replace(b.String(), "usingbtree", "", 1)
+}
+
+// Introspects the table, returning nil if it does not exist
+func introspect(ctx context.Context, db Querier, schema, name string) (*existingTable, error) {
+	var oid *uint32
+
+	err := db.QueryRow(ctx, "SELECT to_regclass($1)::oid", helpers.QuoteIdent(schema, name)).Scan(&oid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if oid == nil {
+		return nil, nil
+	}
+
+	ex := &existingTable{
+		Columns:     map[string]existingColumn{},
+		Constraints: map[string]existingConstraint{},
+		Indexes:     map[string]string{},
+	}
+
+	rows, err := db.Query(ctx, `SELECT a.attname, format_type(a.atttypid, a.atttypmod), NOT a.attnotnull, COALESCE(pg_get_expr(d.adbin, d.adrelid), '')
+	FROM pg_attribute a LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+	WHERE a.attrelid = $1 AND a.attnum > 0 AND NOT a.attisdropped`, *oid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var colName string
+		var col existingColumn
+
+		if err := rows.Scan(&colName, &col.Type, &col.Nullable, &col.Default); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		ex.Columns[colName] = col
+	}
+
+	rows.Close()
+
+	rows, err = db.Query(ctx, "SELECT conname, contype::text, pg_get_constraintdef(oid) FROM pg_constraint WHERE conrelid = $1 AND contype IN ('p', 'u', 'f')", *oid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var conName string
+		var con existingConstraint
+
+		if err := rows.Scan(&conName, &con.Type, &con.Def); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		ex.Constraints[conName] = con
+	}
+
+	rows.Close()
+
+	// Indexes backing a constraint are handled with the constraint
+	rows, err = db.Query(ctx, `SELECT i.relname, pg_get_indexdef(x.indexrelid) FROM pg_index x JOIN pg_class i ON i.oid = x.indexrelid
+	WHERE x.indrelid = $1 AND NOT EXISTS (SELECT 1 FROM pg_constraint c WHERE c.conindid = x.indexrelid)`, *oid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var idxName, idxDef string
+
+		if err := rows.Scan(&idxName, &idxDef); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		ex.Indexes[idxName] = idxDef
+	}
+
+	rows.Close()
+
+	rows, err = db.Query(ctx, `SELECT a.attname FROM pg_index x JOIN pg_attribute a ON a.attrelid = x.indrelid AND a.attnum = ANY(x.indkey)
+	WHERE x.indrelid = $1 AND x.indisprimary ORDER BY array_position(x.indkey::int2[], a.attnum)`, *oid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var colName string
+
+		if err := rows.Scan(&colName); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		ex.PrimaryKey = append(ex.PrimaryKey, colName)
+	}
+
+	rows.Close()
+
+	return ex, rows.Err()
+}
+
+// Computes the changes needed to bring the existing table in line with the definition.
+//
+// Constraints and indexes are matched by name, those whose definition changed are dropped and recreated
+func (t Table) diff(ex *existingTable, schema string) []schemaChange {
+	var changes []schemaChange
+
+	tableName := helpers.QuoteIdent(schema, t.DstName)
+	pkey := t.pkey()
+
+	alter := func(desc, sqlStr string, destructive bool) {
+		changes = append(changes, schemaChange{
+			Desc:        desc,
+			SQL:         "ALTER TABLE " + tableName + " " + sqlStr,
+			Destructive: destructive,
+		})
+	}
+
+	wantPkey := pkey.Columns
+	if pkey.Generated() {
+		wantPkey = []string{pkey.Name}
+	}
+
+	pkeyChanged := !slices.Equal(ex.PrimaryKey, wantPkey)
+
+	// Definitions of the constraints and indexes we want, by name
+	wantConstraints := map[string]string{}
+	for _, col := range t.Columns {
+		for _, c := range col.Constraints.Raw() {
+			wantConstraints[t.constraintName(col, c)] = c.SQL(schema, col.DstName)
+		}
+	}
+
+	wantIndexes := map[string]string{}
+	for _, idx := range t.allIndexes() {
+		wantIndexes[idx.Name] = idx.SQL(schema, t.DstName)
+	}
+
+	// Existing constraints and indexes whose definition changed, these are dropped and added again
+	changedConstraints := map[string]bool{}
+	changedIndexes := map[string]bool{}
+
+	// Drop what is no longer wanted first, so columns can be dropped afterwards
+	for idxName, idxDef := range ex.Indexes {
+		want, ok := wantIndexes[idxName]
+
+		if !ok {
+			changes = append(changes, schemaChange{
+				Desc:        "drop index " + idxName,
+				SQL:         "DROP INDEX " + helpers.QuoteIdent(schema, idxName),
+				Destructive: true,
+			})
+		} else if normalizeDef(idxDef, schema) != normalizeDef(want, schema) {
+			changedIndexes[idxName] = true
+			changes = append(changes, schemaChange{
+				Desc: "drop changed index " + idxName,
+				SQL:  "DROP INDEX " + helpers.QuoteIdent(schema, idxName),
+			})
+		}
+	}
+
+	for conName, con := range ex.Constraints {
+		if con.Type == "p" {
+			if pkeyChanged {
+				alter("drop primary key "+conName, "DROP CONSTRAINT "+helpers.QuoteIdent(conName), true)
+			}
+			continue
+		}
+
+		want, ok := wantConstraints[conName]
+
+		if !ok {
+			alter("drop constraint "+conName, "DROP CONSTRAINT "+helpers.QuoteIdent(conName), true)
+		} else if normalizeDef(con.Def, schema) != normalizeDef(want, schema) {
+			changedConstraints[conName] = true
+			alter("drop changed constraint "+conName, "DROP CONSTRAINT "+helpers.QuoteIdent(conName), false)
+		}
+	}
+
+	// Columns
+	wantCols := map[string]bool{}
+	for _, col := range t.Columns {
+		wantCols[col.DstName] = true
+
+		exCol, ok := ex.Columns[col.DstName]
+
+		if !ok {
+			alter("add column "+col.DstName, "ADD COLUMN "+t.columnSQL(col), false)
+			continue
+		}
+
+		colName := helpers.QuoteIdent(col.DstName)
+
+		if exCol.Type != canonicalType(col) {
+			alter("change type of "+col.DstName+" from "+exCol.Type+" to "+col.SQLType(), "ALTER COLUMN "+colName+" TYPE "+col.SQLType()+" USING "+colName+"::"+col.SQLType(), true)
+		}
+
+		if exCol.Nullable != col.Nullable {
+			if col.Nullable {
+				alter("drop not null on "+col.DstName, "ALTER COLUMN "+colName+" DROP NOT NULL", false)
+			} else {
+				alter("set not null on "+col.DstName, "ALTER COLUMN "+colName+" SET NOT NULL", false)
+			}
+		}
+
+		if normalizeDefault(exCol.Default) != normalizeDefault(col.DefaultSQL()) {
+			if col.DefaultSQL() == "" {
+				alter("drop default on "+col.DstName, "ALTER COLUMN "+colName+" DROP DEFAULT", false)
+			} else {
+				alter("set default on "+col.DstName, "ALTER COLUMN "+colName+" SET DEFAULT "+col.DefaultSQL(), false)
+			}
+		}
+	}
+
+	if pkey.Generated() {
+		wantCols[pkey.Name] = true
+	}
+
+	for colName := range ex.Columns {
+		if !wantCols[colName] {
+			alter("drop column "+colName, "DROP COLUMN "+helpers.QuoteIdent(colName), true)
+		}
+	}
+
+	// Now add the primary key, constraints and indexes
+	if pkeyChanged {
+		if _, ok := ex.Columns[pkey.Name]; pkey.Generated() && !ok {
+			alter("add primary key column "+pkey.Name, "ADD COLUMN "+pkey.ColumnSQL(), false)
+		} else if pkey.Generated() {
+			alter("add primary key on "+pkey.Name, "ADD CONSTRAINT "+helpers.QuoteIdent(t.DstName+"_pkey")+" PRIMARY KEY ("+helpers.QuoteIdent(pkey.Name)+")", false)
+		} else {
+			alter("add primary key on "+strings.Join(pkey.Columns, ","), "ADD CONSTRAINT "+helpers.QuoteIdent(t.DstName+"_pkey")+" "+pkey.ConstraintSQL(), false)
+		}
+	}
+
+	for _, col := range t.Columns {
+		for _, c := range col.Constraints.Raw() {
+			conName := t.constraintName(col, c)
+
+			if _, ok := ex.Constraints[conName]; !ok || changedConstraints[conName] {
+				alter("add constraint "+conName, "ADD CONSTRAINT "+helpers.QuoteIdent(conName)+" "+c.SQL(schema, col.DstName), false)
+			}
+		}
+	}
+
+	for _, idx := range t.allIndexes() {
+		if _, ok := ex.Indexes[idx.Name]; !ok || changedIndexes[idx.Name] {
+			changes = append(changes, schemaChange{
+				Desc: "add index " + idx.Name,
+				SQL:  idx.SQL(schema, t.DstName),
+			})
+		}
+	}
+
+	return changes
+}
+
+// Evolves the table in place, creating it if it does not exist yet
+func (t Table) evolve(ctx context.Context, db Querier, opts Options, rep *report.Table) {
+	schema := opts.TargetSchema()
+
+	ex, err := introspect(ctx, db, schema, t.DstName)
+
+	if err != nil {
+		panic(err)
+	}
+
+	if ex == nil {
+		ui.NotifyMsg("info", "Table "+t.DstName+" does not exist yet, creating it")
+		t.create(ctx, db, schema, rep)
+		return
+	}
+
+	// Existing rows are replaced by the migration, unless they are being upserted
+	if !opts.Incremental {
+		t.execDDL(ctx, db, rep, "DELETE FROM "+helpers.QuoteIdent(schema, t.DstName))
+	}
+
+	changes := t.diff(ex, schema)
+
+	var skippedPkey bool
+	for _, change := range changes {
+		if change.Destructive && !opts.AllowDestructive {
+			ui.NotifyMsg("warning", "Skipping destructive change on "+t.DstName+": "+change.Desc)
+
+			if strings.HasPrefix(change.Desc, "drop primary key") {
+				skippedPkey = true
+			}
+
+			continue
+		}
+
+		// The old primary key is still in place, so a new one cannot be added
+		if skippedPkey && strings.HasPrefix(change.Desc, "add primary key") {
+			ui.NotifyMsg("warning", "Skipping change on "+t.DstName+" as the old primary key was kept: "+change.Desc)
+			continue
+		}
+
+		ui.NotifyMsg("info", "Applying change on "+t.DstName+": "+change.Desc)
+		t.execDDL(ctx, db, rep, change.SQL)
+	}
+}
@@ -0,0 +1,194 @@
+package table
+
+import (
+	"pouncecat/column"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestNormalizeDefault(t *testing.T) {
+	tests := []struct {
+		def, want string
+	}{
+		{"", ""},
+		{"NULL", ""},
+		{"NULL::text", ""},
+		{"0", "0"},
+		{"'x'::text", "'x'"},
+		{"'Mixed Case'::text", "'Mixed Case'"},
+		{"'{}'::jsonb", "'{}'"},
+		{"'{}'::text[]", "'{}'"},
+		{"NOW()", "now()"},
+		{"'2020-01-01 00:00:00+00'::timestamp with time zone", "'2020-01-01 00:00:00+00'"},
+		{"uuid_generate_v4()", "uuid_generate_v4()"},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeDefault(tt.def); got != tt.want {
+			t.Errorf("normalizeDefault(%q) = %q, want %q", tt.def, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeDef(t *testing.T) {
+	tests := []struct {
+		ours, postgres string
+		equal          bool
+	}{
+		{`UNIQUE ("user_id")`, "UNIQUE (user_id)", true},
+		{`FOREIGN KEY ("owner") REFERENCES "public"."users"("user_id") ON UPDATE CASCADE ON DELETE CASCADE`, "FOREIGN KEY (owner) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE CASCADE", true},
+		{`FOREIGN KEY ("owner") REFERENCES "public"."users"("user_id") ON UPDATE CASCADE ON DELETE CASCADE`, "FOREIGN KEY (owner) REFERENCES users(user_id) ON DELETE SET NULL", false},
+		{`CREATE INDEX "bots_votes_idx" ON "public"."bots" ("votes")`, "CREATE INDEX bots_votes_idx ON public.bots USING btree (votes)", true},
+		{`CREATE INDEX "bots_votes_idx" ON "public"."bots" USING gin ("votes")`, "CREATE INDEX bots_votes_idx ON public.bots USING btree (votes)", false},
+		{`CREATE UNIQUE INDEX "bots_vanity_idx" ON "public"."bots" (lower(vanity)) WHERE state = 'Approved'`, "CREATE UNIQUE INDEX bots_vanity_idx ON public.bots USING btree (lower(vanity)) WHERE (state = 'Approved'::text)", true},
+		{`CREATE UNIQUE INDEX "bots_vanity_idx" ON "public"."bots" (lower(vanity)) WHERE state = 'approved'`, "CREATE UNIQUE INDEX bots_vanity_idx ON public.bots USING btree (lower(vanity)) WHERE (state = 'Approved'::text)", false},
+		{`CREATE INDEX "bots_votes_idx" ON "public"."bots" ("votes") INCLUDE ("owner")`, "CREATE INDEX bots_votes_idx ON public.bots USING btree (votes) INCLUDE (owner)", true},
+		{`CREATE INDEX "bots_votes_idx" ON "public"."bots" ("votes")`, "CREATE INDEX bots_votes_idx ON public.bots USING btree (votes) INCLUDE (owner)", false},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeDef(tt.ours, "public") == normalizeDef(tt.postgres, "public"); got != tt.equal {
+			t.Errorf("%q and %q compared equal %v, want %v", tt.ours, tt.postgres, got, tt.equal)
+		}
+	}
+}
+
+func evolveTable() Table {
+	return Table{
+		DstName:    "bots",
+		PrimaryKey: KeyColumns("bot_id"),
+		Columns: []*column.Column{
+			column.NewText("botID", "bot_id", nil),
+			column.NewText("owner", "owner", nil).SetForeignKey([2]string{"users", "user_id"}),
+			column.NewInt("votes", "votes", 0),
+		},
+		Indexes: []*Index{NewIndex("bots_votes_idx", "votes")},
+	}
+}
+
+// The table as postgres reports it after creating evolveTable
+func evolveExisting() *existingTable {
+	return &existingTable{
+		Columns: map[string]existingColumn{
+			"bot_id": {Type: "text"},
+			"owner":  {Type: "text"},
+			"votes":  {Type: "integer", Default: "0"},
+		},
+		Constraints: map[string]existingConstraint{
+			"bots_pkey":     {Type: "p", Def: "PRIMARY KEY (bot_id)"},
+			"bots_owner_fk": {Type: "f", Def: "FOREIGN KEY (owner) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE CASCADE"},
+		},
+		Indexes: map[string]string{
+			"bots_votes_idx": "CREATE INDEX bots_votes_idx ON public.bots USING btree (votes)",
+		},
+		PrimaryKey: []string{"bot_id"},
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(ex *existingTable)
+		// Descriptions of the expected changes, destructive ones prefixed with !
+		want []string
+	}{
+		{
+			name:   "up to date",
+			change: func(ex *existingTable) {},
+		},
+		{
+			name:   "missing column",
+			change: func(ex *existingTable) { delete(ex.Columns, "votes") },
+			want:   []string{"add column votes"},
+		},
+		{
+			name:   "extra column",
+			change: func(ex *existingTable) { ex.Columns["old"] = existingColumn{Type: "text", Nullable: true} },
+			want:   []string{"!drop column old"},
+		},
+		{
+			name:   "type",
+			change: func(ex *existingTable) { ex.Columns["votes"] = existingColumn{Type: "bigint", Default: "0"} },
+			want:   []string{"!change type of votes from bigint to int"},
+		},
+		{
+			name:   "nullable",
+			change: func(ex *existingTable) { ex.Columns["owner"] = existingColumn{Type: "text", Nullable: true} },
+			want:   []string{"set not null on owner"},
+		},
+		{
+			name:   "default",
+			change: func(ex *existingTable) { ex.Columns["votes"] = existingColumn{Type: "integer", Default: "1"} },
+			want:   []string{"set default on votes"},
+		},
+		{
+			name:   "extra default",
+			change: func(ex *existingTable) { ex.Columns["owner"] = existingColumn{Type: "text", Default: "'nobody'::text"} },
+			want:   []string{"drop default on owner"},
+		},
+		{
+			name: "constraint definition",
+			change: func(ex *existingTable) {
+				ex.Constraints["bots_owner_fk"] = existingConstraint{Type: "f", Def: "FOREIGN KEY (owner) REFERENCES users(user_id) ON DELETE SET NULL"}
+			},
+			want: []string{"add constraint bots_owner_fk", "drop changed constraint bots_owner_fk"},
+		},
+		{
+			name:   "missing constraint",
+			change: func(ex *existingTable) { delete(ex.Constraints, "bots_owner_fk") },
+			want:   []string{"add constraint bots_owner_fk"},
+		},
+		{
+			name: "extra constraint",
+			change: func(ex *existingTable) {
+				ex.Constraints["bots_votes_unique"] = existingConstraint{Type: "u", Def: "UNIQUE (votes)"}
+			},
+			want: []string{"!drop constraint bots_votes_unique"},
+		},
+		{
+			name: "index definition",
+			change: func(ex *existingTable) {
+				ex.Indexes["bots_votes_idx"] = "CREATE INDEX bots_votes_idx ON public.bots USING btree (votes DESC)"
+			},
+			want: []string{"add index bots_votes_idx", "drop changed index bots_votes_idx"},
+		},
+		{
+			name: "extra index",
+			change: func(ex *existingTable) {
+				ex.Indexes["bots_owner_idx"] = "CREATE INDEX bots_owner_idx ON public.bots USING btree (owner)"
+			},
+			want: []string{"!drop index bots_owner_idx"},
+		},
+		{
+			name: "primary key",
+			change: func(ex *existingTable) {
+				ex.Columns["itag"] = existingColumn{Type: "uuid", Default: "uuid_generate_v4()"}
+				ex.PrimaryKey = []string{"itag"}
+			},
+			want: []string{"!drop column itag", "!drop primary key bots_pkey", "add primary key on bot_id"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ex := evolveExisting()
+			tt.change(ex)
+
+			var got []string
+			for _, change := range evolveTable().diff(ex, "public") {
+				if change.Destructive {
+					got = append(got, "!"+change.Desc)
+				} else {
+					got = append(got, change.Desc)
+				}
+			}
+
+			sort.Strings(got)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("changes %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
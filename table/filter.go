@@ -0,0 +1,136 @@
+package table
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// Decides whether a source record is migrated at all, evaluated before transforms
+type Filter struct {
+	// Shown in logs when a record is dropped
+	Name string
+	Keep func(record map[string]any) bool
+}
+
+// Keeps the records fn returns true for
+func Keep(name string, fn func(record map[string]any) bool) Filter {
+	return Filter{Name: name, Keep: fn}
+}
+
+// Drops the records fn returns true for
+func Drop(name string, fn func(record map[string]any) bool) Filter {
+	return Filter{Name: name, Keep: func(record map[string]any) bool { return !fn(record) }}
+}
+
+// Operators of filter expressions, longest first so <= starting at the same position is not read as <
+var exprOps = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+// Keeps the records matching a "field op value" expression, such as deleted != true or votes >= 10.
+// Operators are == != < <= > >=, the value is a JSON literal (null, true, 1.5, "text") or a bare string.
+// Missing fields are null. Panics if the expression is invalid
+func Expr(expr string) Filter {
+	var field, op, raw string
+
+	// The leftmost operator splits the expression, so operators inside the value are kept in it
+	at := -1
+	for _, o := range exprOps {
+		if i := strings.Index(expr, o); i >= 0 && (at < 0 || i < at) {
+			at, op = i, o
+		}
+	}
+
+	if at > 0 {
+		field, raw = strings.TrimSpace(expr[:at]), strings.TrimSpace(expr[at+len(op):])
+	}
+
+	if field == "" || raw == "" {
+		panic("invalid filter expression: " + expr)
+	}
+
+	var value any
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		value = raw
+	}
+
+	return Filter{Name: expr, Keep: func(record map[string]any) bool {
+		return compareExpr(record[field], op, value)
+	}}
+}
+
+// Numbers are compared as numbers whatever their type, anything else must be equal or a string to be ordered
+func compareExpr(v any, op string, want any) bool {
+	if vf, ok := toFloat(v); ok {
+		if wf, ok := toFloat(want); ok {
+			switch op {
+			case "==":
+				return vf == wf
+			case "!=":
+				return vf != wf
+			case "<":
+				return vf < wf
+			case "<=":
+				return vf <= wf
+			case ">":
+				return vf > wf
+			case ">=":
+				return vf >= wf
+			}
+		}
+	}
+
+	switch op {
+	case "==":
+		return reflect.DeepEqual(v, want)
+	case "!=":
+		return !reflect.DeepEqual(v, want)
+	}
+
+	vs, ok1 := v.(string)
+	ws, ok2 := want.(string)
+
+	if !ok1 || !ok2 {
+		return false
+	}
+
+	switch op {
+	case "<":
+		return vs < ws
+	case "<=":
+		return vs <= ws
+	case ">":
+		return vs > ws
+	case ">=":
+		return vs >= ws
+	}
+
+	return false
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+
+	return 0, false
+}
+
+// Returns the filter dropping the record, nil if it is kept
+func (t Table) filtered(record map[string]any) *Filter {
+	for i := range t.Filters {
+		if !t.Filters[i].Keep(record) {
+			return &t.Filters[i]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package table
+
+import "testing"
+
+func TestExpr(t *testing.T) {
+	tests := []struct {
+		expr   string
+		record map[string]any
+		want   bool
+	}{
+		{"userID != null", map[string]any{"userID": "1"}, true},
+		{"userID != null", map[string]any{}, false},
+		{"userID != null", map[string]any{"userID": nil}, false},
+		{"deleted != true", map[string]any{}, true},
+		{"deleted != true", map[string]any{"deleted": true}, false},
+		{"deleted == false", map[string]any{"deleted": false}, true},
+		{"votes >= 10", map[string]any{"votes": int64(10)}, true},
+		{"votes >= 10", map[string]any{"votes": int32(9)}, false},
+		{"votes > 10", map[string]any{"votes": 10.5}, true},
+		{"votes < 10", map[string]any{"votes": 3}, true},
+		{"votes == 10", map[string]any{"votes": int64(10)}, true},
+		{"votes != 10", map[string]any{"votes": float32(10)}, false},
+		{"votes<=1", map[string]any{"votes": 1}, true},
+		{"votes >= 10", map[string]any{"votes": "10"}, false},
+		{"votes >= 10", map[string]any{}, false},
+		{`name == "bob"`, map[string]any{"name": "bob"}, true},
+		{"state == approved", map[string]any{"state": "approved"}, true},
+		{"state == approved", map[string]any{"state": "denied"}, false},
+		{`name < "m"`, map[string]any{"name": "alice"}, true},
+		{`name >= "m"`, map[string]any{"name": "alice"}, false},
+		{`name < "m"`, map[string]any{"name": 1}, false},
+		{`name != "a==b"`, map[string]any{"name": "bob"}, true},
+		{`name != "a==b"`, map[string]any{"name": "a==b"}, false},
+		{`name == "a!=b"`, map[string]any{"name": "a!=b"}, true},
+		{`name < "x>=y"`, map[string]any{"name": "a"}, true},
+		{`name < "x>=y"`, map[string]any{"name": "z"}, false},
+		{`name <= "x<y"`, map[string]any{"name": "x<y"}, true},
+		{`name >= "a<=b"`, map[string]any{"name": "b"}, true},
+	}
+
+	for _, tt := range tests {
+		if got := Expr(tt.expr).Keep(tt.record); got != tt.want {
+			t.Errorf("Expr(%q) on %v = %v, want %v", tt.expr, tt.record, got, tt.want)
+		}
+	}
+}
+
+func TestExprInvalid(t *testing.T) {
+	for _, expr := range []string{"", "votes", "== 10", "votes >=", "  != null"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expr(%q) did not panic", expr)
+				}
+			}()
+
+			Expr(expr)
+		}()
+	}
+}
+
+func TestFiltered(t *testing.T) {
+	tbl := Table{Filters: []Filter{
+		Expr("userID != null"),
+		Drop("bots", func(record map[string]any) bool { return record["bot"] == true }),
+	}}
+
+	if f := tbl.filtered(map[string]any{"userID": "1"}); f != nil {
+		t.Errorf("record dropped by %s", f.Name)
+	}
+
+	if f := tbl.filtered(map[string]any{}); f == nil || f.Name != "userID != null" {
+		t.Errorf("record without userID dropped by %v, want userID != null", f)
+	}
+
+	if f := tbl.filtered(map[string]any{"userID": "1", "bot": true}); f == nil || f.Name != "bots" {
+		t.Errorf("bot record dropped by %v, want bots", f)
+	}
+}
@@ -0,0 +1,125 @@
+package table
+
+import (
+	"errors"
+	"pouncecat/helpers"
+	"strings"
+)
+
+type IndexMethod string
+
+const (
+	IndexMethodBTree IndexMethod = "btree"
+	IndexMethodGIN   IndexMethod = "gin"
+	IndexMethodGiST  IndexMethod = "gist"
+	IndexMethodHash  IndexMethod = "hash"
+)
+
+type Index struct {
+	// Name of the index
+	Name string
+	// Columns or expressions (such as lower(vanity)) to index
+	Columns []string
+	// Whether the index is a UNIQUE index
+	Unique bool
+	// Index method, defaults to btree
+	Method IndexMethod
+	// Partial index predicate (WHERE clause without the WHERE)
+	Where string
+	// Non-key columns to INCLUDE in the index
+	Include []string
+}
+
+func NewIndex(name string, cols ...string) *Index {
+	return &Index{
+		Name:    name,
+		Columns: cols,
+	}
+}
+
+func (i *Index) SetUnique(b bool) *Index {
+	i.Unique = b
+	return i
+}
+
+func (i *Index) SetMethod(m IndexMethod) *Index {
+	i.Method = m
+	return i
+}
+
+func (i *Index) SetWhere(where string) *Index {
+	i.Where = where
+	return i
+}
+
+func (i *Index) SetInclude(cols ...string) *Index {
+	i.Include = cols
+	return i
+}
+
+// To make things more ergonomic
+func Indexes(idx ...*Index) []*Index {
+	return idx
+}
+
+func (i *Index) Validate() error {
+	if err := helpers.ValidateIdent(i.Name); err != nil {
+		return err
+	}
+
+	if len(i.Columns) == 0 {
+		return errors.New("index " + i.Name + " has no columns")
+	}
+
+	switch i.Method {
+	case "", IndexMethodBTree, IndexMethodGIN, IndexMethodGiST, IndexMethodHash:
+	default:
+		return errors.New("index " + i.Name + " has unknown method " + string(i.Method))
+	}
+
+	for _, col := range i.Include {
+		if err := helpers.ValidateIdent(col); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the CREATE INDEX statement for the index on the given table
+func (i *Index) SQL(schema, tableName string) string {
+	sqlStr := "CREATE "
+
+	if i.Unique {
+		sqlStr += "UNIQUE "
+	}
+
+	sqlStr += "INDEX " + helpers.QuoteIdent(i.Name) + " ON " + helpers.QuoteIdent(schema, tableName)
+
+	if i.Method != "" {
+		sqlStr += " USING " + string(i.Method)
+	}
+
+	// Expressions such as lower(vanity) are passed through as is, plain columns are quoted
+	exprs := make([]string, len(i.Columns))
+
+	for n, col := range i.Columns {
+		if helpers.IsIdent(col) {
+			exprs[n] = helpers.QuoteIdent(col)
+		} else {
+			exprs[n] = col
+		}
+	}
+
+	sqlStr += " (" + strings.Join(exprs, ",") + ")"
+
+	if len(i.Include) > 0 {
+		sqlStr += " INCLUDE (" + helpers.QuoteIdents(i.Include) + ")"
+	}
+
+	if i.Where != "" {
+		sqlStr += " WHERE " + i.Where
+	}
+
+	return sqlStr
+}
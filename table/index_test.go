@@ -0,0 +1,72 @@
+package table
+
+import "testing"
+
+func TestIndexSQL(t *testing.T) {
+	tests := []struct {
+		name  string
+		index *Index
+		want  string
+	}{
+		{
+			name:  "plain",
+			index: NewIndex("bots_owner_idx", "owner"),
+			want:  `CREATE INDEX "bots_owner_idx" ON "public"."bots" ("owner")`,
+		},
+		{
+			name:  "multiple columns",
+			index: NewIndex("bots_owner_votes_idx", "owner", "votes"),
+			want:  `CREATE INDEX "bots_owner_votes_idx" ON "public"."bots" ("owner","votes")`,
+		},
+		{
+			name:  "gin",
+			index: NewIndex("bots_tags_idx", "tags").SetMethod(IndexMethodGIN),
+			want:  `CREATE INDEX "bots_tags_idx" ON "public"."bots" USING gin ("tags")`,
+		},
+		{
+			name:  "unique expression",
+			index: NewIndex("bots_vanity_idx", "lower(vanity)").SetUnique(true),
+			want:  `CREATE UNIQUE INDEX "bots_vanity_idx" ON "public"."bots" (lower(vanity))`,
+		},
+		{
+			name:  "column and expression",
+			index: NewIndex("bots_owner_vanity_idx", "owner", "lower(vanity)"),
+			want:  `CREATE INDEX "bots_owner_vanity_idx" ON "public"."bots" ("owner",lower(vanity))`,
+		},
+		{
+			name:  "partial with include",
+			index: NewIndex("bots_approved_idx", "votes").SetWhere("state = 'approved'").SetInclude("bot_id", "owner"),
+			want:  `CREATE INDEX "bots_approved_idx" ON "public"."bots" ("votes") INCLUDE ("bot_id","owner") WHERE state = 'approved'`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.index.SQL("public", "bots"); got != tt.want {
+				t.Errorf("SQL() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndexValidate(t *testing.T) {
+	tests := []struct {
+		index *Index
+		ok    bool
+	}{
+		{NewIndex("bots_owner_idx", "owner"), true},
+		{NewIndex("bots_vanity_idx", "lower(vanity)").SetUnique(true), true},
+		{NewIndex("bots_tags_idx", "tags").SetMethod(IndexMethodGIN), true},
+		{NewIndex("bots_votes_idx", "votes").SetInclude("owner"), true},
+		{NewIndex("Bad Name", "owner"), false},
+		{NewIndex("bots_idx"), false},
+		{NewIndex("bots_tags_idx", "tags").SetMethod("brin2"), false},
+		{NewIndex("bots_votes_idx", "votes").SetInclude("a;drop"), false},
+	}
+
+	for _, tt := range tests {
+		if err := tt.index.Validate(); (err == nil) != tt.ok {
+			t.Errorf("Validate(%+v) = %v, want ok %v", tt.index, err, tt.ok)
+		}
+	}
+}
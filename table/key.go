@@ -0,0 +1,75 @@
+package table
+
+import (
+	"errors"
+	"pouncecat/helpers"
+)
+
+// The primary key of a table. Either a list of (existing) columns or a generated surrogate column
+type PrimaryKey struct {
+	// Columns making up the primary key, may be composite
+	Columns []string
+	// Name of the generated surrogate column (when Columns is empty)
+	Name string
+	// SQL type of the generated surrogate column (uuid, bigserial etc.)
+	Type string
+	// SQL default of the generated surrogate column, if any
+	Default string
+}
+
+// The default primary key, a generated itag UUID column
+var DefaultPrimaryKey = GeneratedKey("itag", "uuid").SetDefault("uuid_generate_v4()")
+
+// Primary key made up of one or more columns of the table
+func KeyColumns(cols ...string) *PrimaryKey {
+	return &PrimaryKey{Columns: cols}
+}
+
+// Primary key on a generated surrogate column with the given name and type
+func GeneratedKey(name, sqlType string) *PrimaryKey {
+	return &PrimaryKey{Name: name, Type: sqlType}
+}
+
+func (k *PrimaryKey) SetDefault(defValue string) *PrimaryKey {
+	k.Default = defValue
+	return k
+}
+
+// Whether the primary key is a generated surrogate column
+func (k *PrimaryKey) Generated() bool {
+	return len(k.Columns) == 0
+}
+
+// Column definition of the generated surrogate column
+func (k *PrimaryKey) ColumnSQL() string {
+	sqlStr := helpers.QuoteIdent(k.Name) + " " + k.Type + " PRIMARY KEY NOT NULL"
+
+	if k.Default != "" {
+		sqlStr += " DEFAULT " + k.Default
+	}
+
+	return sqlStr
+}
+
+// Constraint definition for a column based primary key
+func (k *PrimaryKey) ConstraintSQL() string {
+	return "PRIMARY KEY (" + helpers.QuoteIdents(k.Columns) + ")"
+}
+
+func (k *PrimaryKey) Validate() error {
+	if k.Generated() {
+		if k.Type == "" {
+			return errors.New("generated key " + k.Name + " has no type")
+		}
+
+		return helpers.ValidateIdent(k.Name)
+	}
+
+	for _, col := range k.Columns {
+		if err := helpers.ValidateIdent(col); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
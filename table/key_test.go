@@ -0,0 +1,47 @@
+package table
+
+import "testing"
+
+func TestPrimaryKeySQL(t *testing.T) {
+	if got, want := DefaultPrimaryKey.ColumnSQL(), `"itag" uuid PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4()`; got != want {
+		t.Errorf("ColumnSQL() = %s, want %s", got, want)
+	}
+
+	if got, want := GeneratedKey("id", "bigserial").ColumnSQL(), `"id" bigserial PRIMARY KEY NOT NULL`; got != want {
+		t.Errorf("ColumnSQL() = %s, want %s", got, want)
+	}
+
+	if got, want := KeyColumns("bot_id", "user_id").ConstraintSQL(), `PRIMARY KEY ("bot_id","user_id")`; got != want {
+		t.Errorf("ConstraintSQL() = %s, want %s", got, want)
+	}
+}
+
+func TestPrimaryKeyValidate(t *testing.T) {
+	tests := []struct {
+		key *PrimaryKey
+		ok  bool
+	}{
+		{DefaultPrimaryKey, true},
+		{KeyColumns("bot_id"), true},
+		{KeyColumns("bot_id", "user_id"), true},
+		{GeneratedKey("id", ""), false},
+		{GeneratedKey("Bad Name", "uuid"), false},
+		{KeyColumns("bot_id", "lower(vanity)"), false},
+	}
+
+	for _, tt := range tests {
+		if err := tt.key.Validate(); (err == nil) != tt.ok {
+			t.Errorf("Validate(%+v) = %v, want ok %v", tt.key, err, tt.ok)
+		}
+	}
+}
+
+func TestPkey(t *testing.T) {
+	if (Table{}).pkey() != DefaultPrimaryKey {
+		t.Error("tables without a primary key do not use DefaultPrimaryKey")
+	}
+
+	if key := KeyColumns("bot_id"); (Table{PrimaryKey: key}).pkey() != key {
+		t.Error("declared primary key not used")
+	}
+}
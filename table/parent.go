@@ -0,0 +1,105 @@
+package table
+
+import (
+	"context"
+	"fmt"
+	"pouncecat/checkpoint"
+	"pouncecat/helpers"
+	"pouncecat/report"
+	"pouncecat/ui"
+	"strconv"
+	"strings"
+)
+
+// Inserts stub rows into the parent tables of EnsureParent columns whose keys are missing, so the row does not fail
+// on its foreign keys. known caches parent keys that already exist, so each is only checked once. Stubs are counted
+// per parent table in stubs, to be added to their checkpoints with countStubs once committed
+func (t Table) ensureParents(ctx context.Context, db Querier, schema string, colNames []string, args []any, known map[string]bool, rep *report.Table, stubs map[string]int64) error {
+	for _, col := range t.Columns {
+		if col.EnsureParent == nil {
+			continue
+		}
+
+		var key any
+
+		for i, name := range colNames {
+			if name == col.DstName {
+				key = args[i]
+			}
+		}
+
+		if key == nil {
+			continue
+		}
+
+		parent, parentCol := col.Constraints.ForeignKey[0], col.Constraints.ForeignKey[1]
+		cacheKey := parent + "/" + parentCol + "/" + fmt.Sprint(key)
+
+		if known[cacheKey] {
+			continue
+		}
+
+		var exists bool
+
+		err := db.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM "+helpers.QuoteIdent(schema, parent)+" WHERE "+helpers.QuoteIdent(parentCol)+" = $1)", key).Scan(&exists)
+
+		if err != nil {
+			return fmt.Errorf("column %s: checking parent: %w", col.DstName, err)
+		}
+
+		if !exists {
+			cols, values := col.EnsureParent.Row(ctx, key)
+
+			cols = append([]string{parentCol}, cols...)
+			values = append([]any{key}, values...)
+
+			argQuotes := make([]string, len(cols))
+
+			for i := range cols {
+				argQuotes[i] = "$" + strconv.Itoa(i+1)
+			}
+
+			sqlStr := "INSERT INTO " + helpers.QuoteIdent(schema, parent) + " (" + helpers.QuoteIdents(cols) + ") VALUES (" + strings.Join(argQuotes, ",") + ") ON CONFLICT DO NOTHING"
+
+			tag, err := db.Exec(ctx, sqlStr, values...)
+
+			if err != nil {
+				return fmt.Errorf("column %s: inserting stub parent: %w", col.DstName, err)
+			}
+
+			// Someone else may have inserted the parent in the meantime
+			if tag.RowsAffected() > 0 {
+				ui.Log.Info("Inserted stub parent", ui.F("table", t.DstName), ui.F("parent", parent), ui.F("key", key))
+				rep.AddStub(parent, fmt.Sprint(key))
+				stubs[parent]++
+			}
+		}
+
+		known[cacheKey] = true
+	}
+
+	return nil
+}
+
+// Adds the stubs counted by ensureParents to the checkpoints of their parent tables (if set) and clears them, so
+// verifying those can tell them from migrated rows. Should only be called once the stubs are committed
+func countStubs(ctx context.Context, checkpoints checkpoint.Store, stubs map[string]int64) {
+	for parent, n := range stubs {
+		delete(stubs, parent)
+
+		if checkpoints == nil {
+			continue
+		}
+
+		state, err := checkpoints.Get(ctx, parent)
+
+		if err == nil {
+			state.Stubs += n
+			err = checkpoints.Set(ctx, parent, state)
+		}
+
+		if err != nil {
+			ui.NotifyMsg("error", "Could not count stubs of "+parent+" in its checkpoint: "+err.Error())
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package table
+
+import (
+	"context"
+	"pouncecat/column"
+	"pouncecat/helpers"
+	"pouncecat/report"
+	"pouncecat/ui"
+	"strings"
+)
+
+// Returns the primary key of the table, falling back to DefaultPrimaryKey
+func (t Table) pkey() *PrimaryKey {
+	if t.PrimaryKey == nil {
+		return DefaultPrimaryKey
+	}
+
+	return t.PrimaryKey
+}
+
+// Returns all indexes of the table, including the IndexCols index
+func (t Table) allIndexes() []*Index {
+	var indexes []*Index
+
+	if len(t.IndexCols) > 0 {
+		indexes = append(indexes, NewIndex(t.DstName+"_migindex", t.IndexCols...))
+	}
+
+	return append(indexes, t.Indexes...)
+}
+
+func (t Table) constraintName(col *column.Column, c column.RawConstraint) string {
+	return t.DstName + "_" + col.DstName + "_" + c.Type
+}
+
+func (t Table) columnSQL(col *column.Column) string {
+	return helpers.QuoteIdent(col.DstName) + " " + col.SQLType() + " " + strings.Join(col.Meta(), " ")
+}
+
+func (t Table) execDDL(ctx context.Context, db Querier, rep *report.Table, sqlStr string) {
+	rep.AddDDL(sqlStr)
+
+	_, err := db.Exec(ctx, sqlStr)
+
+	if err != nil {
+		ui.Log.Error("DDL failed", ui.F("table", t.DstName), ui.F("sql", sqlStr), ui.F("error", err))
+		panic(err)
+	}
+}
+
+// Drops and recreates the table from scratch
+func (t Table) create(ctx context.Context, db Querier, schema string, rep *report.Table) {
+	tableName := helpers.QuoteIdent(schema, t.DstName)
+	pkey := t.pkey()
+
+	t.execDDL(ctx, db, rep, "DROP TABLE IF EXISTS "+tableName)
+
+	if pkey.Generated() {
+		t.execDDL(ctx, db, rep, "CREATE TABLE "+tableName+" ("+pkey.ColumnSQL()+")")
+	} else {
+		t.execDDL(ctx, db, rep, "CREATE TABLE "+tableName+" ()")
+	}
+
+	// Create columns firstly
+	for _, v := range t.Columns {
+		t.execDDL(ctx, db, rep, "ALTER TABLE "+tableName+" ADD COLUMN IF NOT EXISTS "+t.columnSQL(v))
+
+		// Now add constraints
+		for _, c := range v.Constraints.Raw() {
+			t.execDDL(ctx, db, rep, "ALTER TABLE "+tableName+" ADD CONSTRAINT "+helpers.QuoteIdent(t.constraintName(v, c))+" "+c.SQL(schema, v.DstName))
+		}
+	}
+
+	if !pkey.Generated() {
+		t.execDDL(ctx, db, rep, "ALTER TABLE "+tableName+" ADD CONSTRAINT "+helpers.QuoteIdent(t.DstName+"_pkey")+" "+pkey.ConstraintSQL())
+	}
+
+	for _, idx := range t.allIndexes() {
+		t.execDDL(ctx, db, rep, idx.SQL(schema, t.DstName))
+	}
+}
@@ -0,0 +1,161 @@
+package table
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"pouncecat/helpers"
+	"pouncecat/source"
+	"pouncecat/ui"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"golang.org/x/exp/slices"
+)
+
+// Returns the source entities of the tables that can be synced (those with a ConflictKey)
+func SyncEntities(tables []Table) []string {
+	var entities []string
+
+	for _, t := range tables {
+		if len(t.ConflictKey) > 0 && !slices.Contains(entities, t.SrcName) {
+			entities = append(entities, t.SrcName)
+		}
+	}
+
+	return entities
+}
+
+// Applies a single source change to the table, upserting on the ConflictKey or deleting the matching row.
+// Changes are always written to the live schema, so this should only be used after any SwapSchemas
+func (t Table) ApplyChange(ctx context.Context, src source.Source, db Querier, opts Options, change source.Change) error {
+	tableName := helpers.QuoteIdent(opts.schema(), t.DstName)
+
+	switch change.Op {
+	case source.ChangeOpUpsert:
+		// Rows already migrated are left alone if the record stops matching
+		if t.filtered(change.Record) != nil {
+			return nil
+		}
+
+		colNames, args, skip := t.parseRecord(t.transformContext(ctx, src, db, opts.SourceWriter, 0), change.Record)
+
+		if skip {
+			return nil
+		}
+
+		// There is nothing else to do while waiting, so ask right away
+		if hasDeferred(args) {
+			if !t.resolveHeld(ctx, opts, []parsedDataStruct{{Args: args}})[0] {
+				return nil
+			}
+		}
+
+		stubs := map[string]int64{}
+
+		if err := t.ensureParents(ctx, db, opts.schema(), colNames, args, map[string]bool{}, nil, stubs); err != nil {
+			return err
+		}
+
+		if _, err := db.Exec(ctx, t.insertSQL(opts.schema(), colNames, true), args...); err != nil {
+			return err
+		}
+
+		countStubs(ctx, opts.Checkpoints, stubs)
+
+		return nil
+	case source.ChangeOpDelete:
+		// The conflict key can only be computed from the record as it was before deletion
+		if change.Record == nil {
+			ui.NotifyMsg("warning", "Cannot delete from "+t.DstName+" without the deleted record, enable changeStreamPreAndPostImages on "+change.Entity+" and request pre-images from the source (mongo 6.0+)")
+			return nil
+		}
+
+		// Only the key is needed, the transforms of the other columns may not even work on a deleted record
+		colNames, args, skip := t.parseColumns(t.transformContext(ctx, src, db, opts.SourceWriter, 0), change.Record, t.ConflictKey)
+
+		if skip {
+			return nil
+		}
+
+		var conds []string
+		var keyArgs []any
+
+		for i, col := range colNames {
+			for _, key := range t.ConflictKey {
+				if col == key {
+					keyArgs = append(keyArgs, args[i])
+					conds = append(conds, helpers.QuoteIdent(col)+" = $"+strconv.Itoa(len(keyArgs)))
+				}
+			}
+		}
+
+		if len(conds) != len(t.ConflictKey) {
+			return fmt.Errorf("deleted record is missing part of the conflict key %v", t.ConflictKey)
+		}
+
+		_, err := db.Exec(ctx, "DELETE FROM "+tableName+" WHERE "+strings.Join(conds, " AND "), keyArgs...)
+		return err
+	}
+
+	return fmt.Errorf("unknown change op %d", change.Op)
+}
+
+// Tails changes from the source after a bulk migration and applies them to the tables until ctx is cancelled.
+// Tables without a ConflictKey cannot be synced and are skipped
+func Sync(ctx context.Context, src source.WatchableSource, pool *pgxpool.Pool, opts Options, tables []Table) error {
+	var bySrc = map[string][]Table{}
+
+	for _, t := range tables {
+		if len(t.ConflictKey) == 0 {
+			ui.NotifyMsg("warning", "Table "+t.DstName+" has no conflict key, not syncing it")
+			continue
+		}
+
+		bySrc[t.SrcName] = append(bySrc[t.SrcName], t)
+	}
+
+	// Transforms panic on bad records, which would otherwise stop the watcher for good
+	apply := func(t Table, change source.Change) (failed any, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				if rerr, ok := r.(error); ok && ctx.Err() != nil && errors.Is(rerr, ctx.Err()) {
+					panic(r)
+				}
+
+				failed = r
+			}
+		}()
+
+		return nil, t.ApplyChange(ctx, src, pool, opts, change)
+	}
+
+	return src.Watch(ctx, SyncEntities(tables), func(change source.Change) error {
+		for _, t := range bySrc[change.Entity] {
+			failed, err := apply(t, change)
+
+			if failed != nil {
+				ui.Log.Error("Skipping change that failed to transform", ui.F("table", t.DstName), ui.F("key", change.Key), ui.F("error", failed))
+				opts.Metrics.TransformError(t.DstName)
+				continue
+			}
+
+			if err != nil {
+				if t.IgnoreFKError && strings.Contains(err.Error(), "violates foreign key") {
+					ui.Log.Warn("Ignoring foreign key error on change", ui.F("table", t.DstName), ui.F("error", err))
+					continue
+				} else if t.IgnoreUniqueError && strings.Contains(err.Error(), "unique constraint") {
+					ui.Log.Warn("Ignoring unique error on change", ui.F("table", t.DstName), ui.F("error", err))
+					continue
+				}
+
+				return fmt.Errorf("table %s: %w", t.DstName, err)
+			}
+
+			ui.Log.Debug("Applied change", ui.F("table", t.DstName), ui.F("key", change.Key))
+		}
+
+		return nil
+	})
+}
@@ -2,20 +2,31 @@ package table
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"pouncecat/checkpoint"
 	"pouncecat/column"
+	"pouncecat/helpers"
+	"pouncecat/metrics"
+	"pouncecat/report"
+	"pouncecat/resolve"
 	"pouncecat/source"
 	"pouncecat/ui"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"golang.org/x/exp/slices"
 )
 
-var ctx = context.Background()
+// How many rows are inserted (and committed) between checkpoints
+const checkpointEvery = 500
 
-var Records []map[string]any
+// Satisfied by both *pgxpool.Pool and pgx.Tx, so migrations can run with or without a transaction
+type Querier = helpers.Querier
 
 type Table struct {
 	// The source name of the table.
@@ -24,192 +35,959 @@ type Table struct {
 	DstName string
 	// The columns of the table.
 	Columns []*column.Column
-	// Columns to index
+	// Primary key of the table, defaults to DefaultPrimaryKey
+	PrimaryKey *PrimaryKey
+	// Columns to index, creates a single <table>_migindex btree index
 	IndexCols []string
+	// Indexes to create on the table
+	Indexes []*Index
 	// Ignore Foreign Key Errors
 	IgnoreFKError bool
 	// Ignore unique constraint errors
 	IgnoreUniqueError bool
 	// May not be on source?
 	IgnoreMissing bool
+	// Source field records are ordered and checkpointed by, defaults to the source's own key (_id in mongo)
+	SrcKey string
+	// Destination columns identifying a row for upserts in incremental mode, must be unique (such as the primary key)
+	ConflictKey []string
+	// Source field holding the last modification time, incremental syncs only fetch records changed since the last sync
+	UpdatedField string
+	// Records not kept by every filter are dropped before transforms run
+	Filters []Filter
+	// Collapses records sharing a key before transforms run, instead of relying on IgnoreUniqueError
+	Dedup *Dedup
 }
 
-func PrepareTables(pool *pgxpool.Pool) {
-	pool.Exec(ctx, `DROP SCHEMA public CASCADE;
-	CREATE SCHEMA public;
-	GRANT ALL ON SCHEMA public TO postgres;
-	GRANT ALL ON SCHEMA public TO public;
-	COMMENT ON SCHEMA public IS 'standard public schema'`)
+// Schema the uuid-ossp extension is created in by swap mode, so swapping schemas never drops it
+const ExtensionSchema = "pouncecat_ext"
 
-	pool.Exec(ctx, "CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\"")
+// Run-wide options for PrepareTables and Migrate
+type Options struct {
+	// Evolve existing tables in place instead of dropping and recreating them. Existing rows are still replaced
+	Evolve bool
+	// Allow destructive schema changes (dropping columns, constraints and indexes, changing types) when evolving
+	AllowDestructive bool
+	// Schema to migrate into, defaults to public
+	Schema string
+	// Migrate into a fresh <schema>_staging schema and swap it into place with SwapSchemas once all tables succeed
+	Swap bool
+	// Run the DDL and data load of each table in a single transaction, rolling it back on failure
+	Transactional bool
+	// Where to record progress, checkpointing is disabled if nil
+	Checkpoints checkpoint.Store
+	// Skip tables completed by a previous run and continue partially migrated ones
+	Resume bool
+	// Upsert changed records into existing tables instead of reloading them, implies Evolve.
+	// Tables without a ConflictKey are skipped
+	Incremental bool
+	// Where to record what happened during the run, nothing is recorded if nil
+	Report *report.Report
+	// Live counters for monitoring, nothing is collected if nil
+	Metrics *metrics.Metrics
+	// Answers questions of transforms returning a *resolve.Deferred
+	Resolver *resolve.Manager
+	// Lets transforms change source records (see column.TransformContext.Writer), nil if they may not
+	SourceWriter source.WritableSource
+	// Schema the uuid-ossp extension lives in, as returned by PrepareTables. Defaults to ExtensionSchema
+	ExtSchema string
 }
 
-// To ensure data is parsed before being inserted into the database, we use a temporary struct
-type parsedDataStruct struct {
-	SQL  string
-	Args []any
+func (o Options) schema() string {
+	if o.Schema == "" {
+		return "public"
+	}
+
+	return o.Schema
 }
 
-func (t Table) Migrate(src source.Source, pool *pgxpool.Pool) {
-	records, err := src.GetRecords(t.SrcName)
+// The schema tables are actually created in, this is the staging schema in swap mode
+func (o Options) TargetSchema() string {
+	if o.Swap {
+		return o.schema() + "_staging"
+	}
 
-	Records = records // Just in case it is needed
+	return o.schema()
+}
 
-	if err != nil {
-		if t.IgnoreMissing {
-			ui.NotifyMsg("info", "Table %s not found on source, skipping "+t.SrcName)
-			records = []map[string]any{}
-		} else {
+// The schema the previous schema is kept as after SwapSchemas
+func (o Options) BackupSchema() string {
+	return o.schema() + "_backup"
+}
+
+// The search_path connections should use, so hand-written SQL in transforms sees the migrated tables and uuid
+// defaults resolve
+func (o Options) SearchPath() string {
+	return o.searchPath(o.TargetSchema())
+}
+
+// The schema the migrated tables end up in, where the staging schema is swapped into
+func (o Options) LiveSchema() string {
+	return o.schema()
+}
+
+// The search_path connections should use after SwapSchemas
+func (o Options) LiveSearchPath() string {
+	return o.searchPath(o.LiveSchema())
+}
+
+func (o Options) searchPath(schema string) string {
+	ext := o.ExtSchema
+
+	if ext == "" {
+		ext = ExtensionSchema
+	}
+
+	if ext == schema {
+		return helpers.QuoteIdent(schema)
+	}
+
+	return helpers.QuoteIdents([]string{schema, ext})
+}
+
+// Whether existing tables are kept and changed in place
+func (o Options) inPlace() bool {
+	return o.Evolve || o.Incremental
+}
+
+func (o Options) Validate() error {
+	if o.Swap && o.inPlace() {
+		return errors.New("swap cannot be used with evolve or incremental, swap always migrates into a fresh schema")
+	}
+
+	for _, name := range []string{o.TargetSchema(), o.BackupSchema()} {
+		if err := helpers.ValidateIdent(name); err != nil {
+			return fmt.Errorf("schema: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Prepares the target schema and returns the schema the uuid-ossp extension lives in, which should be set as
+// Options.ExtSchema so it is on the search path of the connections creating tables
+func PrepareTables(ctx context.Context, pool *pgxpool.Pool, opts Options) string {
+	if err := opts.Validate(); err != nil {
+		panic(err)
+	}
+
+	schema := helpers.QuoteIdent(opts.TargetSchema())
+
+	// Schema DDL goes into the run report
+	exec := func(sqlStr string) (pgconn.CommandTag, error) {
+		opts.Report.AddDDL(sqlStr)
+		return pool.Exec(ctx, sqlStr)
+	}
+
+	// Checked before anything is dropped, as dropping the schema holding it would take the defaults of the live
+	// tables along
+	if opts.Swap {
+		ensureExtension(ctx, pool, exec, opts)
+	}
+
+	// The schema was already prepared by the run being resumed
+	if opts.Resume {
+		return ensureExtension(ctx, pool, exec, opts)
+	}
+
+	// Incremental syncs need the last sync times of the previous run
+	if opts.Checkpoints != nil && !opts.Incremental {
+		if err := opts.Checkpoints.Reset(ctx); err != nil {
 			panic(err)
 		}
 	}
 
-	bar := ui.StartBar(t.DstName, 2, true)
+	if opts.inPlace() {
+		exec("CREATE SCHEMA IF NOT EXISTS " + schema)
+		return ensureExtension(ctx, pool, exec, opts)
+	}
+
+	exec(`DROP SCHEMA IF EXISTS ` + schema + ` CASCADE;
+	CREATE SCHEMA ` + schema)
 
-	cbar := ui.StartBar("collect info", int64(len(records)), false)
+	exec("GRANT ALL ON SCHEMA " + schema + " TO postgres")
+	exec("GRANT ALL ON SCHEMA " + schema + " TO public")
 
-	_, err = pool.Exec(ctx, "DROP TABLE IF EXISTS "+t.DstName)
+	if opts.schema() == "public" {
+		exec("COMMENT ON SCHEMA " + schema + " IS 'standard public schema'")
+	}
+
+	return ensureExtension(ctx, pool, exec, opts)
+}
+
+// Returns the schema the uuid-ossp extension lives in, creating it if missing: in ExtensionSchema in swap mode, in
+// the target schema otherwise. An existing one is never moved, but in swap mode it must not live in a schema swapping
+// renames or drops, or dropping the backup would take it along (and the defaults of the live tables with it)
+func ensureExtension(ctx context.Context, pool *pgxpool.Pool, exec func(string) (pgconn.CommandTag, error), opts Options) string {
+	var extSchema string
+
+	err := pool.QueryRow(ctx, "SELECT n.nspname FROM pg_extension e JOIN pg_namespace n ON n.oid = e.extnamespace WHERE e.extname = 'uuid-ossp'").Scan(&extSchema)
+
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		panic(err)
+	}
+
+	if extSchema == "" {
+		extSchema = opts.TargetSchema()
+
+		if opts.Swap {
+			extSchema = ExtensionSchema
+
+			if _, err := exec("CREATE SCHEMA IF NOT EXISTS " + helpers.QuoteIdent(ExtensionSchema)); err != nil {
+				panic(err)
+			}
+		}
+
+		if _, err := exec("CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\" WITH SCHEMA " + helpers.QuoteIdent(extSchema)); err != nil {
+			panic(err)
+		}
+
+		return extSchema
+	}
+
+	if opts.Swap && (extSchema == opts.schema() || extSchema == opts.TargetSchema() || extSchema == opts.BackupSchema()) {
+		panic("the uuid-ossp extension is installed in schema " + extSchema + ", which swapping renames or drops. Move it first with: CREATE SCHEMA " + ExtensionSchema + "; ALTER EXTENSION \"uuid-ossp\" SET SCHEMA " + ExtensionSchema)
+	}
+
+	return extSchema
+}
+
+// Atomically swaps the staging schema into place, keeping the previous schema as a backup.
+// Should only be called once all tables have been migrated
+func SwapSchemas(ctx context.Context, pool *pgxpool.Pool, opts Options) {
+	if !opts.Swap {
+		return
+	}
+
+	tx, err := pool.Begin(ctx)
 
 	if err != nil {
 		panic(err)
 	}
 
-	// For the purposes of having a primary key
-	_, err = pool.Exec(ctx, "CREATE TABLE "+t.DstName+" (itag UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4())")
+	defer tx.Rollback(ctx)
+
+	var exists bool
+
+	err = tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM pg_namespace WHERE nspname = $1)", opts.schema()).Scan(&exists)
 
 	if err != nil {
 		panic(err)
 	}
 
-	// Create columns firstly
-	for _, v := range t.Columns {
-		_, err := pool.Exec(ctx, "ALTER TABLE "+t.DstName+" ADD COLUMN IF NOT EXISTS "+v.DstName+" "+v.SQLType()+" "+strings.Join(v.Meta(), " "))
+	stmts := []string{"DROP SCHEMA IF EXISTS " + helpers.QuoteIdent(opts.BackupSchema()) + " CASCADE"}
+
+	if exists {
+		stmts = append(stmts, "ALTER SCHEMA "+helpers.QuoteIdent(opts.schema())+" RENAME TO "+helpers.QuoteIdent(opts.BackupSchema()))
+	}
+
+	stmts = append(stmts, "ALTER SCHEMA "+helpers.QuoteIdent(opts.TargetSchema())+" RENAME TO "+helpers.QuoteIdent(opts.schema()))
+
+	for _, stmt := range stmts {
+		opts.Report.AddDDL(stmt)
+
+		if _, err := tx.Exec(ctx, stmt); err != nil {
+			ui.Log.Error("Swapping schemas failed", ui.F("sql", stmt), ui.F("error", err))
+			panic(err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		panic(err)
+	}
+
+	ui.NotifyMsg("info", "Swapped "+opts.TargetSchema()+" into "+opts.schema()+", previous schema kept as "+opts.BackupSchema())
+}
+
+// A source record picked for migration
+type selectedRecord struct {
+	Record map[string]any
+	// Source record whose key the row is checkpointed by. This is Record itself unless duplicates were collapsed
+	// into it, then it is the last record of the group in source order
+	At map[string]any
+}
+
+// Drops filtered records and collapses duplicates, calling reject (if set) for each record dropped
+func (t Table) selectRecords(src source.Source, records []map[string]any, reject func(reason report.SkipReason)) []selectedRecord {
+	if reject == nil {
+		reject = func(report.SkipReason) {}
+	}
+
+	var selected []selectedRecord
+
+	for _, record := range records {
+		if f := t.filtered(record); f != nil {
+			ui.Log.Debug("Dropping filtered record", ui.F("table", t.DstName), ui.F("filter", f.Name))
+			reject(report.SkipFiltered)
+			continue
+		}
+
+		selected = append(selected, selectedRecord{Record: record, At: record})
+	}
+
+	if t.Dedup != nil {
+		var dropped int
+		selected, dropped = t.Dedup.apply(src, selected)
+
+		for i := 0; i < dropped; i++ {
+			reject(report.SkipDuplicate)
+		}
+
+		if dropped > 0 {
+			ui.Log.Info("Collapsed duplicate records", ui.F("table", t.DstName), ui.F("dropped", dropped), ui.F("strategy", t.Dedup.Strategy))
+		}
+	}
+
+	return selected
+}
+
+// Returns just the records of selected
+func selectedRecords(selected []selectedRecord) []map[string]any {
+	records := make([]map[string]any, len(selected))
+
+	for i, sel := range selected {
+		records[i] = sel.Record
+	}
+
+	return records
+}
+
+// Context for the transforms of a record, the column is filled in by columnValue. writer may be nil
+func (t Table) transformContext(ctx context.Context, src source.Source, db Querier, writer source.WritableSource, row int) *column.TransformContext {
+	return &column.TransformContext{
+		Ctx:    ctx,
+		Table:  t.DstName,
+		Row:    row,
+		Source: src,
+		Dest:   db,
+		Writer: writer,
+		Log:    ui.Log.With(ui.F("table", t.DstName)),
+	}
+}
+
+// Transforms a source record into the column names and values to insert, or skip if the row should be skipped
+func (t Table) parseRecord(tc *column.TransformContext, record map[string]any) (colNames []string, args []any, skip bool) {
+	return t.parseColumns(tc, record, nil)
+}
+
+// Like parseRecord, but only computes the given columns (all if nil), so the transforms of the others do not run
+func (t Table) parseColumns(tc *column.TransformContext, record map[string]any, only []string) (colNames []string, args []any, skip bool) {
+	args = []any{}
+	colNames = []string{}
+
+	for _, col := range t.Columns {
+		if only != nil && !slices.Contains(only, col.DstName) {
+			continue
+		}
+
+		arg, omit, skip := t.columnValue(tc, record, col)
+
+		if skip {
+			return nil, nil, true
+		}
+
+		if omit {
+			continue
+		}
+
+		args = append(args, arg)
+		colNames = append(colNames, col.DstName)
+	}
+
+	return colNames, args, false
+}
+
+// Computes the value of a single column for a record. If omit is set, the column should be left to its SQL default,
+// if skip is set, the whole row should be skipped
+func (t Table) columnValue(tc *column.TransformContext, record map[string]any, col *column.Column) (arg any, omit bool, skip bool) {
+	arg = record[col.SrcName]
+	count := tc.Row
+
+	if len(col.Transforms) > 0 {
+		colTC := *tc
+		colTC.Column = col
+		colTC.Log = tc.Log.With(ui.F("column", col.DstName))
+
+		for _, transform := range col.Transforms {
+			arg = transform(&colTC, record, arg)
+		}
+	}
+
+	// Filled in once the question is answered
+	if _, ok := arg.(*resolve.Deferred); ok {
+		return arg, false, false
+	}
+
+	extParsed, err := tc.Source.ExtParse(arg)
+
+	if err == nil {
+		arg = extParsed
+	}
+
+	if arg == "none" {
+		arg = nil
+	}
+
+	var panicF bool
+
+	if arg == "PANIC" {
+		arg = nil
+		panicF = true
+	}
+
+	if arg == nil {
+		if col.Default != nil {
+			arg = col.Default
+		}
+
+		if col.SQLDefault != "" && arg == nil {
+			arg = col.SQLDefault
+		}
+
+		if arg == "NULL" {
+			arg = nil
+		}
+
+		if arg == "uuid_generate_v4()" {
+			return nil, true, false
+		}
+
+		if arg == "SKIP" {
+			ui.Log.Warn("Skipping row due to default value", ui.F("table", t.DstName), ui.F("column", col.DstName), ui.F("iteration", count))
+			return nil, false, true
+		} else if panicF {
+			panic("Panic due to default value at iteration " + strconv.Itoa(count) + " on column " + col.SrcName)
+		}
+	}
+
+	return arg, false, false
+}
+
+// To ensure data is parsed before being inserted into the database, we use a temporary struct
+type parsedDataStruct struct {
+	SQL     string
+	Columns []string
+	Args    []any
+	// Source key of the record, for checkpoints
+	Key any
+}
+
+// Checks that all names used by the table are safe to use in SQL
+func (t Table) Validate() error {
+	if err := helpers.ValidateIdent(t.DstName); err != nil {
+		return fmt.Errorf("table %s: %w", t.SrcName, err)
+	}
+
+	for _, col := range t.Columns {
+		if err := col.Validate(); err != nil {
+			return fmt.Errorf("table %s: %w", t.DstName, err)
+		}
+	}
+
+	for _, col := range t.ConflictKey {
+		if err := helpers.ValidateIdent(col); err != nil {
+			return fmt.Errorf("table %s: conflict key: %w", t.DstName, err)
+		}
+	}
+
+	if t.PrimaryKey != nil {
+		if err := t.PrimaryKey.Validate(); err != nil {
+			return fmt.Errorf("table %s: primary key: %w", t.DstName, err)
+		}
+	}
+
+	for _, idx := range t.Indexes {
+		if err := idx.Validate(); err != nil {
+			return fmt.Errorf("table %s: index: %w", t.DstName, err)
+		}
+	}
+
+	for _, f := range t.Filters {
+		if f.Keep == nil {
+			return fmt.Errorf("table %s: filter %s has no Keep func", t.DstName, f.Name)
+		}
+	}
+
+	if t.Dedup != nil {
+		if err := t.Dedup.Validate(); err != nil {
+			return fmt.Errorf("table %s: %w", t.DstName, err)
+		}
+	}
+
+	return nil
+}
+
+// Validates a set of tables, should be called before any DDL is run
+func ValidateTables(tables []Table) error {
+	var seen = map[string]bool{}
+
+	for _, t := range tables {
+		if err := t.Validate(); err != nil {
+			return err
+		}
+
+		if seen[t.DstName] {
+			return fmt.Errorf("table %s: declared more than once", t.DstName)
+		}
+
+		seen[t.DstName] = true
+	}
+
+	return nil
+}
+
+func (t Table) Migrate(ctx context.Context, src source.Source, pool *pgxpool.Pool, opts Options) {
+	if err := t.Validate(); err != nil {
+		panic(err)
+	}
+
+	if opts.Incremental && len(t.ConflictKey) == 0 {
+		ui.NotifyMsg("warning", "Table "+t.DstName+" has no conflict key, skipping in incremental mode")
+		return
+	}
+
+	rep := opts.Report.Table(t.DstName)
+
+	opts.Metrics.SetCurrentTable(t.DstName)
+	defer opts.Metrics.SetCurrentTable("")
+
+	defer func() {
+		if r := recover(); r != nil {
+			rep.Finish(fmt.Sprint(r))
+			panic(r)
+		}
+	}()
+
+	// Changes made while the table is being migrated are picked up by the next incremental sync
+	startedAt := time.Now()
+
+	var state checkpoint.State
+
+	if opts.Checkpoints != nil {
+		var err error
+		state, err = opts.Checkpoints.Get(ctx, t.DstName)
 
 		if err != nil {
 			panic(err)
 		}
 
-		// Now add constraints
-		for _, c := range v.Constraints.Raw() {
-			_, err = pool.Exec(ctx, "ALTER TABLE "+t.DstName+" ADD CONSTRAINT "+t.DstName+"_"+v.DstName+"_"+c.Type+" "+c.SQL(v.DstName))
+		if opts.Resume && state.Done && !opts.Incremental {
+			ui.NotifyMsg("info", "Table "+t.DstName+" was already migrated, skipping")
+			rep.MarkDone()
+			rep.Finish("")
+			return
+		}
+	}
 
-			if err != nil {
-				fmt.Println("ALTER TABLE " + t.DstName + " ADD CONSTRAINT " + t.DstName + "_" + v.DstName + "_" + c.Type + " " + c.SQL(v.DstName))
-				panic(err)
+	keyed, isKeyed := src.(source.KeyedSource)
+
+	srcKey := t.SrcKey
+	if isKeyed && srcKey == "" {
+		srcKey = keyed.DefaultKey()
+	}
+
+	// Partially migrated tables are continued after the last written key, anything else starts from scratch.
+	// Transactional tables are never partially migrated, they are rolled back instead
+	resuming := opts.Resume && isKeyed && state.LastKey != "" && !opts.Transactional && !opts.Incremental
+
+	if !resuming {
+		// Incremental syncs keep the existing rows, stubs included
+		stubs := state.Stubs
+		state = checkpoint.State{SyncedAt: state.SyncedAt}
+
+		if opts.Incremental {
+			state.Stubs = stubs
+		}
+	}
+
+	var records []map[string]any
+	var err error
+
+	// Set if only changed records are synced, the rejected count of the whole table is unknown then
+	var partial bool
+
+	if opts.Incremental {
+		incr, ok := src.(source.IncrementalSource)
+
+		if t.UpdatedField != "" && ok {
+			partial = !state.SyncedAt.IsZero()
+			records, err = incr.GetRecordsSince(ctx, t.SrcName, t.UpdatedField, state.SyncedAt)
+		} else {
+			if t.UpdatedField != "" {
+				ui.NotifyMsg("warning", "Source cannot fetch changed records only, upserting all records of "+t.SrcName)
 			}
+
+			records, err = src.GetRecords(ctx, t.SrcName)
 		}
+	} else if isKeyed && opts.Checkpoints != nil {
+		// Records must be in key order for the last key to mean anything
+		records, err = keyed.GetRecordsAfter(ctx, t.SrcName, srcKey, state.LastKey)
+	} else {
+		records, err = src.GetRecords(ctx, t.SrcName)
+	}
+
+	if ctx.Err() != nil {
+		panic(ctx.Err())
 	}
 
-	if len(t.IndexCols) > 0 {
-		// Create index on these columns
-		colList := strings.Join(t.IndexCols, ",")
-		indexName := t.DstName + "_migindex"
-		sqlStr := "CREATE INDEX " + indexName + " ON " + t.DstName + "(" + colList + ")"
+	if err != nil {
+		if t.IgnoreMissing {
+			ui.NotifyMsg("info", "Table %s not found on source, skipping "+t.SrcName)
+			records = []map[string]any{}
+		} else {
+			panic(err)
+		}
+	}
 
-		_, pgerr := pool.Exec(ctx, sqlStr)
+	if opts.Report != nil {
+		sourceCount, err := src.GetCount(ctx, t.SrcName)
 
-		if pgerr != nil {
-			panic(pgerr)
+		if err != nil {
+			sourceCount = -1
 		}
+
+		rep.SetCounts(sourceCount, int64(len(records)))
+	}
+
+	// Transforms panic on bad data, record which record it was before going down
+	parse := func(record map[string]any, count int) (colNames []string, args []any, skip bool) {
+		defer func() {
+			if r := recover(); r != nil {
+				rep.TransformError("record " + strconv.Itoa(count) + ": " + fmt.Sprint(r))
+				opts.Metrics.TransformError(t.DstName)
+				panic(r)
+			}
+		}()
+
+		return t.parseRecord(t.transformContext(ctx, src, pool, opts.SourceWriter, count), record)
 	}
 
+	reject := func(reason report.SkipReason) {
+		state.Rejected++
+		rep.Skip(reason)
+		opts.Metrics.RowRejected(t.DstName, string(reason))
+	}
+
+	selected := t.selectRecords(src, records, reject)
+
+	bar := ui.StartBar(t.DstName, 2, true)
+
+	cbar := ui.StartBar("collect info", int64(len(selected)), false)
+
 	var count int = 0
 
 	var parsedData = []parsedDataStruct{}
 
-	for _, record := range records {
+	// Indexes in parsedData of rows waiting for answers to questions of their transforms, in order
+	var held []int
+
+	for _, sel := range selected {
+		record := sel.Record
+
+		// Nothing has been written yet, so there is nothing to clean up
+		if ctx.Err() != nil {
+			panic(ctx.Err())
+		}
+
 		cbar.Increment()
+		opts.Metrics.RowRead(t.DstName)
 		count++
-		var args []any = []any{}
-		var argQuotes []string = []string{}
-		var colNames []string = []string{}
 
-		var realI int
-		for _, col := range t.Columns {
-			arg := record[col.SrcName]
+		colNames, args, skip := parse(record, count)
 
-			for _, transform := range col.Transforms {
-				arg = transform(record, arg)
-			}
+		if skip {
+			reject(report.SkipDefault)
+			continue
+		}
 
-			extParsed, err := src.ExtParse(arg)
+		opts.Metrics.RowTransformed(t.DstName)
 
-			if err == nil {
-				arg = extParsed
-			}
+		sqlStr := t.insertSQL(opts.TargetSchema(), colNames, opts.Incremental)
 
-			if arg == "none" {
-				arg = nil
-			}
+		// A resumed run starts before the first row held by the previous one, so rows inserted after it are
+		// skipped instead of failing. Tables without a unique key cannot tell and get those rows twice
+		if resuming {
+			sqlStr += " ON CONFLICT DO NOTHING"
+		}
+
+		data := parsedDataStruct{
+			SQL:     sqlStr,
+			Columns: colNames,
+			Args:    args,
+			Key:     sel.At[srcKey],
+		}
+
+		if hasDeferred(args) {
+			held = append(held, len(parsedData))
+		}
+
+		parsedData = append(parsedData, data)
+	}
+
+	bar.Increment()
 
-			var panicF bool
+	// Rows whose answers resolve to SKIP
+	unresolved := map[int]bool{}
+
+	askHeld := func() {
+		rows := make([]parsedDataStruct, len(held))
+
+		for n, idx := range held {
+			rows[n] = parsedData[idx]
+		}
 
-			if arg == "PANIC" {
-				arg = nil
-				panicF = true
+		for n, ok := range t.resolveHeld(ctx, opts, rows) {
+			if !ok {
+				unresolved[held[n]] = true
 			}
+		}
+	}
 
-			if arg == nil {
-				if col.Default != nil {
-					arg = col.Default
-				}
+	// Nothing is visible before the transaction commits, so its questions are asked before it starts instead of
+	// while it holds the locks of the table
+	if opts.Transactional && len(held) > 0 {
+		askHeld()
+		held = nil
+	}
 
-				if col.SQLDefault != "" && arg == nil {
-					arg = col.SQLDefault
-				}
+	// Writes are not cancelled by an interrupt, so the current row is finished, the checkpoint saved and the
+	// transaction rolled back cleanly instead. The loops below check ctx between rows
+	wctx := context.Background()
 
-				if arg == "NULL" {
-					arg = nil
-				}
+	// DDL runs after all records are transformed, so transforms can still query other tables freely
+	var db Querier = pool
 
-				if arg == "uuid_generate_v4()" {
-					continue
-				}
+	// Transactional tables are loaded in a single transaction, others in one per checkpointEvery rows, committed
+	// together with their checkpoint so a killed run never leaves rows behind that resuming would insert again
+	var tx pgx.Tx
+
+	begin := func() {
+		if tx != nil {
+			return
+		}
+
+		tx, err = pool.Begin(wctx)
+
+		if err != nil {
+			panic(err)
+		}
+
+		db = tx
+	}
+
+	// Roll back whatever is not committed yet on any failure, transactional tables to their previous state
+	defer func() {
+		if r := recover(); r != nil {
+			if tx != nil {
+				tx.Rollback(wctx)
 
-				if arg == "SKIP" {
-					ui.NotifyMsg("warning", "Skipping row due to default value at iteration "+strconv.Itoa(count))
-					break
-				} else if panicF {
-					panic("Panic due to default value at iteration " + strconv.Itoa(count) + " on column " + col.SrcName)
+				if opts.Transactional {
+					ui.NotifyMsg("error", "Rolled back migration of "+t.DstName)
 				}
 			}
 
-			args = append(args, arg)
-			argQuotes = append(argQuotes, "$"+strconv.Itoa(realI+1))
-			realI++
-			colNames = append(colNames, col.DstName)
+			panic(r)
 		}
+	}()
 
-		parsedData = append(parsedData, parsedDataStruct{
-			SQL:  "INSERT INTO " + t.DstName + " (" + strings.Join(colNames, ",") + ") VALUES (" + strings.Join(argQuotes, ",") + ")",
-			Args: args,
-		})
+	if opts.Transactional {
+		begin()
 	}
 
-	bar.Increment()
+	if resuming {
+		ui.NotifyMsg("info", "Resuming "+t.DstName+" after "+strconv.FormatInt(state.Rows, 10)+" rows")
+	} else if opts.inPlace() {
+		t.evolve(wctx, db, opts, rep)
+	} else {
+		t.create(wctx, db, opts.TargetSchema(), rep)
+	}
+
+	var lastKey any
+
+	// Stubs inserted into parent tables since they were last counted in their checkpoints
+	stubs := map[string]int64{}
+
+	saveCheckpoint := func(checkpoints checkpoint.Store) {
+		// Stubs of a table referencing itself are part of its own state
+		state.Stubs += stubs[t.DstName]
+		delete(stubs, t.DstName)
+
+		countStubs(wctx, checkpoints, stubs)
+
+		if checkpoints == nil {
+			return
+		}
+
+		if isKeyed && lastKey != nil && !opts.Transactional {
+			encoded, err := keyed.EncodeKey(lastKey)
+
+			if err != nil {
+				ui.NotifyMsg("warning", "Could not encode checkpoint key for "+t.DstName+": "+err.Error())
+			} else {
+				state.LastKey = encoded
+			}
+		}
+
+		if err := checkpoints.Set(wctx, t.DstName, state); err != nil {
+			ui.NotifyMsg("error", "Could not save checkpoint for "+t.DstName+": "+err.Error())
+		}
+	}
+
+	// Commits the rows (and stubs) inserted so far and saves their checkpoint, in the same transaction if the store
+	// supports it. Stores that do not are saved right after, leaving a much smaller window
+	commit := func() {
+		if tx == nil {
+			saveCheckpoint(opts.Checkpoints)
+			return
+		}
+
+		txStore, inTx := opts.Checkpoints.(checkpoint.TxStore)
+
+		if inTx {
+			saveCheckpoint(txStore.InTx(tx))
+		}
+
+		if err := tx.Commit(wctx); err != nil {
+			panic(err)
+		}
+
+		tx, db = nil, pool
+
+		if !inTx {
+			saveCheckpoint(opts.Checkpoints)
+		}
+	}
 
 	pbar := ui.StartBar("inserting data", int64(len(parsedData)), false)
 
-	for i, data := range parsedData {
-		pbar.Increment()
+	log := ui.Log.With(ui.F("table", t.DstName))
+
+	// Parent keys known to exist, for EnsureParent columns
+	knownParents := map[string]bool{}
 
-		_, err := pool.Exec(ctx, data.SQL, data.Args...)
+	insert := func(i int, data parsedDataStruct) {
+		begin()
+
+		if err := t.ensureParents(wctx, db, opts.TargetSchema(), data.Columns, data.Args, knownParents, rep, stubs); err != nil {
+			log.Error("Ensuring parents failed", ui.F("iteration", i), ui.F("error", err))
+			panic(err)
+		}
+
+		insertStart := time.Now()
+		inserted, err := t.insertRow(wctx, db, tx, data)
+		opts.Metrics.ObserveInsert(t.DstName, time.Since(insertStart), err == nil)
 
 		if err != nil {
 			if t.IgnoreFKError && strings.Contains(err.Error(), "violates foreign key") {
-				ui.NotifyMsg("warning", "Ignoring foreign key error on iter "+strconv.Itoa(i)+": "+err.Error())
-				continue
+				log.Warn("Ignoring foreign key error", ui.F("iteration", i), ui.F("error", err))
+				reject(report.SkipFKError)
 			} else if t.IgnoreUniqueError && strings.Contains(err.Error(), "unique constraint") {
-				ui.NotifyMsg("warning", "Ignoring unique error on iter "+strconv.Itoa(i)+": "+err.Error())
-				continue
+				log.Warn("Ignoring unique error", ui.F("iteration", i), ui.F("error", err))
+				reject(report.SkipUniqueError)
+			} else {
+				log.Error("Insert failed", ui.F("iteration", i), ui.F("error", err))
+
+				panic(err.Error() + ":" + data.SQL)
+			}
+		} else if inserted || !resuming {
+			state.Rows++
+			rep.Insert()
+		}
+	}
+
+	// Stops between rows, the rows inserted so far are committed (or the transaction rolled back) on the way out
+	interrupted := func() {
+		if ctx.Err() != nil {
+			if !opts.Transactional {
+				commit()
 			}
 
-			ui.NotifyMsg("error", "Error on iter "+strconv.Itoa(i)+": "+err.Error())
+			log.Warn("Interrupted, stopping", ui.F("inserted", state.Rows))
+			panic(ctx.Err())
+		}
+	}
+
+	// Held rows not inserted yet. The checkpoint only moves up to the row before the first of them, so a resumed run
+	// does not miss them
+	pending := held
+
+	var handled int
+
+	// Called once the row at i is inserted or rejected, when all rows up to upTo are, held ones aside
+	done := func(i, upTo int) {
+		pbar.Increment()
+
+		if len(pending) > 0 && pending[0] == i {
+			pending = pending[1:]
+		}
+
+		if len(pending) > 0 && pending[0] <= upTo {
+			upTo = pending[0] - 1
+		}
+
+		if upTo >= 0 {
+			lastKey = parsedData[upTo].Key
+		}
+
+		handled++
+
+		if handled%checkpointEvery == 0 && !opts.Transactional {
+			commit()
+		}
+	}
+
+	isHeld := map[int]bool{}
+
+	for _, idx := range held {
+		isHeld[idx] = true
+	}
+
+	// Rows that are not held first, so one missing answer does not keep the rest of the table waiting
+	for i, data := range parsedData {
+		if isHeld[i] {
+			continue
+		}
+
+		interrupted()
+
+		if unresolved[i] {
+			reject(report.SkipUnresolved)
+		} else {
+			insert(i, data)
+		}
+
+		done(i, i)
+	}
+
+	// Then the held rows, once all their questions are answered in bulk
+	if len(held) > 0 {
+		commit()
+		askHeld()
+
+		for _, i := range held {
+			interrupted()
 
-			panic(err.Error() + ":" + data.SQL)
+			if unresolved[i] {
+				reject(report.SkipUnresolved)
+			} else {
+				insert(i, parsedData[i])
+			}
+
+			done(i, len(parsedData)-1)
 		}
 	}
 
+	state.Done = true
+	state.SyncedAt = startedAt
+
+	if partial {
+		state.Rejected = -1
+	}
+
+	commit()
+
+	rep.Finish("")
+
 	bar.Increment()
 
 	cbar.Abort(true)
@@ -220,3 +998,56 @@ func (t Table) Migrate(src source.Source, pool *pgxpool.Pool) {
 
 	time.Sleep(1 * time.Second)
 }
+
+// Inserts a row, inside a savepoint when in a transaction so ignored errors do not abort the whole transaction.
+// inserted is false if the row was skipped by an ON CONFLICT DO NOTHING
+func (t Table) insertRow(ctx context.Context, db Querier, tx pgx.Tx, data parsedDataStruct) (inserted bool, err error) {
+	if tx == nil || !(t.IgnoreFKError || t.IgnoreUniqueError) {
+		tag, err := db.Exec(ctx, data.SQL, data.Args...)
+		return tag.RowsAffected() > 0, err
+	}
+
+	sp, err := tx.Begin(ctx)
+
+	if err != nil {
+		return false, err
+	}
+
+	tag, err := sp.Exec(ctx, data.SQL, data.Args...)
+
+	if err != nil {
+		sp.Rollback(ctx)
+		return false, err
+	}
+
+	return tag.RowsAffected() > 0, sp.Commit(ctx)
+}
+
+func (t Table) insertSQL(schema string, colNames []string, upsert bool) string {
+	argQuotes := make([]string, len(colNames))
+
+	for i := range colNames {
+		argQuotes[i] = "$" + strconv.Itoa(i+1)
+	}
+
+	sqlStr := "INSERT INTO " + helpers.QuoteIdent(schema, t.DstName) + " (" + helpers.QuoteIdents(colNames) + ") VALUES (" + strings.Join(argQuotes, ",") + ")"
+
+	if !upsert {
+		return sqlStr
+	}
+
+	var sets []string
+	for _, col := range colNames {
+		if !slices.Contains(t.ConflictKey, col) {
+			sets = append(sets, helpers.QuoteIdent(col)+" = EXCLUDED."+helpers.QuoteIdent(col))
+		}
+	}
+
+	sqlStr += " ON CONFLICT (" + helpers.QuoteIdents(t.ConflictKey) + ")"
+
+	if len(sets) == 0 {
+		return sqlStr + " DO NOTHING"
+	}
+
+	return sqlStr + " DO UPDATE SET " + strings.Join(sets, ", ")
+}
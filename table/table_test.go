@@ -0,0 +1,62 @@
+package table
+
+import "testing"
+
+func TestInsertSQL(t *testing.T) {
+	tests := []struct {
+		name        string
+		conflictKey []string
+		schema      string
+		cols        []string
+		upsert      bool
+		want        string
+	}{
+		{
+			name:   "insert",
+			schema: "public",
+			cols:   []string{"bot_id", "votes"},
+			want:   `INSERT INTO "public"."bots" ("bot_id","votes") VALUES ($1,$2)`,
+		},
+		{
+			name:        "insert ignores the conflict key",
+			conflictKey: []string{"bot_id"},
+			schema:      "public_staging",
+			cols:        []string{"bot_id"},
+			want:        `INSERT INTO "public_staging"."bots" ("bot_id") VALUES ($1)`,
+		},
+		{
+			name:        "upsert",
+			conflictKey: []string{"bot_id"},
+			schema:      "public",
+			cols:        []string{"bot_id", "votes", "owner"},
+			upsert:      true,
+			want:        `INSERT INTO "public"."bots" ("bot_id","votes","owner") VALUES ($1,$2,$3) ON CONFLICT ("bot_id") DO UPDATE SET "votes" = EXCLUDED."votes", "owner" = EXCLUDED."owner"`,
+		},
+		{
+			name:        "upsert on a composite key",
+			conflictKey: []string{"bot_id", "user_id"},
+			schema:      "public",
+			cols:        []string{"user_id", "bot_id", "votes"},
+			upsert:      true,
+			want:        `INSERT INTO "public"."bots" ("user_id","bot_id","votes") VALUES ($1,$2,$3) ON CONFLICT ("bot_id","user_id") DO UPDATE SET "votes" = EXCLUDED."votes"`,
+		},
+		{
+			name:        "upsert of key columns only",
+			conflictKey: []string{"bot_id", "user_id"},
+			schema:      "public",
+			cols:        []string{"bot_id", "user_id"},
+			upsert:      true,
+			want:        `INSERT INTO "public"."bots" ("bot_id","user_id") VALUES ($1,$2) ON CONFLICT ("bot_id","user_id") DO NOTHING`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tbl := Table{DstName: "bots", ConflictKey: tt.conflictKey}
+
+			if got := tbl.insertSQL(tt.schema, tt.cols, tt.upsert); got != tt.want {
+				t.Errorf("insertSQL() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,369 @@
+package table
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"pouncecat/helpers"
+	"pouncecat/resolve"
+	"pouncecat/source"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"golang.org/x/exp/slices"
+)
+
+// Result of verifying a single table
+type VerifyResult struct {
+	Table       string `json:"table"`
+	SourceCount int64  `json:"source_count"`
+	DestCount   int64  `json:"dest_count"`
+	// Rejected rows recorded by the migration, -1 if unknown (checkpoints disabled, or after an incremental sync of
+	// changed records)
+	Rejected int64 `json:"rejected"`
+	// Stub rows inserted for the foreign keys of other tables, which have no source record
+	Stubs int64 `json:"stubs"`
+	// Whether the source count matches the destination count (less stubs) plus rejected rows. If the rejected rows
+	// are unknown, whether the destination (less stubs) has no more rows than the source
+	CountOK bool `json:"count_ok"`
+	Sampled int  `json:"sampled"`
+	// Set if rows cannot be sampled (or checksummed) as the table has no key to look them up by
+	NoKey bool `json:"no_key,omitempty"`
+	// Sampled records with no row in the destination
+	Missing    int        `json:"missing"`
+	Mismatches []Mismatch `json:"mismatches"`
+	// Checksums of key ordered ranges of rows, if requested
+	Buckets []ChecksumBucket `json:"buckets,omitempty"`
+	// Set if the table could not be (fully) verified
+	Error string `json:"error,omitempty"`
+}
+
+func (r VerifyResult) OK() bool {
+	return r.CountOK && r.Missing == 0 && len(r.Mismatches) == 0 && r.BadBuckets() == 0 && r.Error == ""
+}
+
+// Number of checksum buckets that differ between the source and the destination
+func (r VerifyResult) BadBuckets() int {
+	var n int
+
+	for _, b := range r.Buckets {
+		if !b.OK() {
+			n++
+		}
+	}
+
+	return n
+}
+
+// A column whose migrated value does not match the transformed source value
+type Mismatch struct {
+	Key      string `json:"key"`
+	Column   string `json:"column"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// The columns identifying a row, nil if rows cannot be identified
+func (t Table) rowKey() []string {
+	if len(t.ConflictKey) > 0 {
+		return t.ConflictKey
+	}
+
+	if pkey := t.pkey(); !pkey.Generated() {
+		return pkey.Columns
+	}
+
+	return nil
+}
+
+// Compares row counts and checks that a random sample of source records was migrated correctly,
+// then compares checksums of all rows in (at most) buckets key ranges if buckets is set.
+// Volatile columns are not compared, tables without a key to look rows up by only have their counts compared
+func (t Table) Verify(ctx context.Context, src source.Source, pool *pgxpool.Pool, opts Options, samples, buckets int) (res VerifyResult) {
+	res = VerifyResult{Table: t.DstName, Rejected: -1}
+
+	var err error
+	res.SourceCount, err = src.GetCount(ctx, t.SrcName)
+
+	if err != nil {
+		res.Error = "source count: " + err.Error()
+		return res
+	}
+
+	tableName := helpers.QuoteIdent(opts.schema(), t.DstName)
+
+	if err := pool.QueryRow(ctx, "SELECT COUNT(*) FROM "+tableName).Scan(&res.DestCount); err != nil {
+		res.Error = "destination count: " + err.Error()
+		return res
+	}
+
+	if opts.Checkpoints != nil {
+		state, err := opts.Checkpoints.Get(ctx, t.DstName)
+
+		if err == nil && state.Done {
+			res.Rejected = state.Rejected
+		}
+
+		if err == nil {
+			res.Stubs = state.Stubs
+		}
+	}
+
+	// Rows are only ever dropped, so without the rejected count only extra rows are known to be wrong
+	if res.Rejected >= 0 {
+		res.CountOK = res.SourceCount == res.DestCount-res.Stubs+res.Rejected
+	} else {
+		res.CountOK = res.SourceCount >= res.DestCount-res.Stubs
+	}
+
+	// Only the counts can be compared
+	if len(t.rowKey()) == 0 {
+		res.NoKey = true
+		return res
+	}
+
+	if samples > 0 {
+		if err := t.verifySample(ctx, src, pool, tableName, samples, &res); err != nil {
+			res.Error = "sampling: " + err.Error()
+			return res
+		}
+	}
+
+	if buckets > 0 {
+		res.Buckets, err = t.Checksum(ctx, src, pool, opts, buckets)
+
+		if err != nil {
+			res.Error = "checksum: " + err.Error()
+		}
+	}
+
+	return res
+}
+
+// Checks that a random sample of source records was migrated correctly
+func (t Table) verifySample(ctx context.Context, src source.Source, pool *pgxpool.Pool, tableName string, samples int, res *VerifyResult) error {
+	key := t.rowKey()
+
+	var records []map[string]any
+	var err error
+
+	// Duplicates can only be collapsed over all records, so the sample is taken after that
+	if sampler, ok := src.(source.SamplingSource); ok && t.Dedup == nil {
+		records, err = sampler.SampleRecords(ctx, t.SrcName, samples)
+		records = selectedRecords(t.selectRecords(src, records, nil))
+	} else {
+		records, err = src.GetRecords(ctx, t.SrcName)
+		records = selectedRecords(t.selectRecords(src, records, nil))
+
+		rand.Shuffle(len(records), func(i, j int) { records[i], records[j] = records[j], records[i] })
+
+		if len(records) > samples {
+			records = records[:samples]
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if err := t.verifyRecord(ctx, src, pool, tableName, key, record, res); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t Table) verifyRecord(ctx context.Context, src source.Source, pool *pgxpool.Pool, tableName string, key []string, record map[string]any, res *VerifyResult) error {
+	tc := t.transformContext(ctx, src, pool, nil, 0)
+
+	var args []any
+	var keyConds []string
+	var keyStr []string
+
+	// Let postgres do the comparison, so values are compared as the column types
+	var selects []string
+	var compared []string
+	var expected []string
+
+	for _, col := range t.Columns {
+		isKey := slices.Contains(key, col.DstName)
+
+		if col.Volatile && !isKey {
+			continue
+		}
+
+		arg, omit, skip := t.columnValue(tc, record, col)
+
+		// Rejected by the migration, nothing to compare
+		if skip {
+			return nil
+		}
+
+		// Needs an answer to compare against
+		if _, ok := arg.(*resolve.Deferred); ok {
+			continue
+		}
+
+		// Left to the database to fill in
+		if omit || (col.SQLDefault != "" && arg == col.SQLDefault) {
+			continue
+		}
+
+		args = append(args, arg)
+		placeholder := "$" + strconv.Itoa(len(args)) + "::" + col.SQLType()
+		colName := helpers.QuoteIdent(col.DstName)
+
+		if isKey {
+			keyConds = append(keyConds, colName+" = "+placeholder)
+			keyStr = append(keyStr, col.DstName+"="+fmt.Sprint(arg))
+		}
+
+		if !col.Volatile {
+			selects = append(selects, "("+colName+" IS NOT DISTINCT FROM "+placeholder+")", colName+"::text")
+			compared = append(compared, col.DstName)
+			expected = append(expected, fmt.Sprint(arg))
+		}
+	}
+
+	if len(keyConds) != len(key) {
+		return fmt.Errorf("record is missing part of the key %v", key)
+	}
+
+	res.Sampled++
+
+	if len(compared) == 0 {
+		return nil
+	}
+
+	rows, err := pool.Query(ctx, "SELECT "+strings.Join(selects, ", ")+" FROM "+tableName+" WHERE "+strings.Join(keyConds, " AND ")+" LIMIT 1", args...)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	if !rows.Next() {
+		if rows.Err() != nil {
+			return rows.Err()
+		}
+
+		res.Missing++
+		return nil
+	}
+
+	values, err := rows.Values()
+
+	if err != nil {
+		return err
+	}
+
+	for i, colName := range compared {
+		if equal, _ := values[i*2].(bool); equal {
+			continue
+		}
+
+		actual := "NULL"
+		if values[i*2+1] != nil {
+			actual = fmt.Sprint(values[i*2+1])
+		}
+
+		res.Mismatches = append(res.Mismatches, Mismatch{
+			Key:      strings.Join(keyStr, ","),
+			Column:   colName,
+			Expected: expected[i],
+			Actual:   actual,
+		})
+	}
+
+	return rows.Err()
+}
+
+// Verifies all tables
+func VerifyTables(ctx context.Context, tables []Table, src source.Source, pool *pgxpool.Pool, opts Options, samples, buckets int) []VerifyResult {
+	var results []VerifyResult
+
+	for _, t := range tables {
+		results = append(results, t.Verify(ctx, src, pool, opts, samples, buckets))
+	}
+
+	return results
+}
+
+// Writes verification results as a human readable table, followed by any mismatches
+func WriteVerifyTable(w io.Writer, results []VerifyResult) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "TABLE\tSOURCE\tDEST\tSTUBS\tREJECTED\tCOUNTS\tSAMPLED\tMISSING\tMISMATCHES\tCHECKSUMS\tERROR")
+
+	for _, r := range results {
+		counts := "ok"
+		if !r.CountOK {
+			counts = "MISMATCH"
+		}
+
+		rejected := "?"
+		if r.Rejected >= 0 {
+			rejected = strconv.FormatInt(r.Rejected, 10)
+		}
+
+		sampled := strconv.Itoa(r.Sampled)
+		if r.NoKey {
+			sampled = "n/a"
+		}
+
+		checksums := "-"
+		if r.NoKey {
+			checksums = "n/a"
+		} else if len(r.Buckets) > 0 {
+			checksums = strconv.Itoa(len(r.Buckets)-r.BadBuckets()) + "/" + strconv.Itoa(len(r.Buckets)) + " ok"
+		}
+
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n", r.Table, r.SourceCount, r.DestCount, r.Stubs, rejected, counts, sampled, r.Missing, len(r.Mismatches), checksums, r.Error)
+	}
+
+	tw.Flush()
+
+	for _, r := range results {
+		if len(r.Mismatches) == 0 {
+			continue
+		}
+
+		fmt.Fprintln(w, "\nMismatches in "+r.Table+":")
+
+		tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+		fmt.Fprintln(tw, "KEY\tCOLUMN\tEXPECTED\tACTUAL")
+
+		for _, m := range r.Mismatches {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", m.Key, m.Column, m.Expected, m.Actual)
+		}
+
+		tw.Flush()
+	}
+
+	for _, r := range results {
+		if r.BadBuckets() == 0 {
+			continue
+		}
+
+		fmt.Fprintln(w, "\nDiffering key ranges in "+r.Table+":")
+
+		tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+		fmt.Fprintln(tw, "BUCKET\tFIRST KEY\tLAST KEY\tSOURCE ROWS\tDEST ROWS")
+
+		for _, b := range r.Buckets {
+			if !b.OK() {
+				fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%d\n", b.Bucket, b.FirstKey, b.LastKey, b.SourceRows, b.DestRows)
+			}
+		}
+
+		tw.Flush()
+	}
+}
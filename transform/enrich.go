@@ -0,0 +1,313 @@
+package transform
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"pouncecat/column"
+	"pouncecat/helpers"
+	"pouncecat/ui"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Returned by resolvers when there is no value for the key, these are not retried
+var ErrNotFound = errors.New("not found")
+
+// Looks up a value for a key from somewhere outside the source, such as an API
+type Resolver interface {
+	Resolve(ctx context.Context, key string) (string, error)
+}
+
+// Lets a plain Go func be used as a Resolver
+type ResolverFunc func(ctx context.Context, key string) (string, error)
+
+func (f ResolverFunc) Resolve(ctx context.Context, key string) (string, error) {
+	return f(ctx, key)
+}
+
+// Fetches a JSON document and returns one of its fields
+type HTTPResolver struct {
+	// URL to fetch, {key} is replaced with the (escaped) key
+	URL string
+	// Dot-separated path of the field to return, such as username or user.name
+	Field  string
+	Client *http.Client
+}
+
+func (h *HTTPResolver) Resolve(ctx context.Context, key string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.ReplaceAll(h.URL, "{key}", url.PathEscape(key)), nil)
+
+	if err != nil {
+		return "", err
+	}
+
+	client := h.Client
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", ErrNotFound
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New("unexpected status " + strconv.Itoa(resp.StatusCode))
+	}
+
+	body, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		return "", err
+	}
+
+	var doc any
+
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return "", err
+	}
+
+	for _, part := range strings.Split(h.Field, ".") {
+		obj, ok := doc.(map[string]any)
+
+		if !ok {
+			return "", fmt.Errorf("field %s: not an object", h.Field)
+		}
+
+		doc, ok = obj[part]
+
+		if !ok {
+			return "", fmt.Errorf("field %s: %w", h.Field, ErrNotFound)
+		}
+	}
+
+	if s, ok := doc.(string); ok {
+		return s, nil
+	}
+
+	return fmt.Sprint(doc), nil
+}
+
+// Enriches records with values looked up through a Resolver, with caching, a concurrency limit,
+// timeouts and retries. Failed lookups are not cached
+type Enricher struct {
+	Resolver Resolver
+	// File resolved values are cached in across runs, the cache is in-memory only if empty
+	CachePath string
+	// Number of newly resolved values after which the cache file is written, defaults to 100. Flush writes the rest
+	FlushEvery int
+	// Maximum number of lookups running at once, defaults to 4
+	Concurrency int
+	// Timeout of a single attempt, defaults to 10 seconds
+	Timeout time.Duration
+	// Number of retries after the first attempt, defaults to 3. Set to -1 to never retry
+	Retries int
+	// Wait before the first retry, doubled after every retry. Defaults to 500ms
+	Backoff time.Duration
+	// Value the transforms return when the lookup fails
+	Fallback any
+
+	init  sync.Once
+	mu    sync.Mutex
+	cache map[string]string
+	sem   chan struct{}
+	// Values resolved since the cache file was last written
+	unsaved int
+}
+
+func (e *Enricher) setup() {
+	e.init.Do(func() {
+		e.cache = map[string]string{}
+
+		if e.Concurrency <= 0 {
+			e.Concurrency = 4
+		}
+
+		if e.Timeout <= 0 {
+			e.Timeout = 10 * time.Second
+		}
+
+		if e.Retries == 0 {
+			e.Retries = 3
+		} else if e.Retries < 0 {
+			e.Retries = 0
+		}
+
+		if e.Backoff <= 0 {
+			e.Backoff = 500 * time.Millisecond
+		}
+
+		if e.FlushEvery <= 0 {
+			e.FlushEvery = 100
+		}
+
+		e.sem = make(chan struct{}, e.Concurrency)
+
+		if e.CachePath == "" {
+			return
+		}
+
+		cache, err := helpers.ReadJSONMap[string](e.CachePath)
+
+		if err != nil {
+			ui.Log.Warn("Could not load enrichment cache, starting empty", ui.F("path", e.CachePath), ui.F("error", err))
+			return
+		}
+
+		e.cache = cache
+	})
+}
+
+// Must be called with e.mu held
+func (e *Enricher) saveCache() error {
+	if e.CachePath == "" {
+		return nil
+	}
+
+	bytes, err := json.MarshalIndent(e.cache, "", "\t")
+
+	if err != nil {
+		return err
+	}
+
+	if err := helpers.WriteFileAtomic(e.CachePath, bytes); err != nil {
+		return err
+	}
+
+	e.unsaved = 0
+
+	return nil
+}
+
+// Writes values resolved since the cache file was last written, should be called once lookups are done
+func (e *Enricher) Flush() error {
+	e.setup()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.unsaved == 0 {
+		return nil
+	}
+
+	return e.saveCache()
+}
+
+// Looks up the value for key, from the cache if possible. Gives up waiting between retries once ctx is done
+func (e *Enricher) Lookup(ctx context.Context, key string) (string, error) {
+	e.setup()
+
+	e.mu.Lock()
+	v, ok := e.cache[key]
+	e.mu.Unlock()
+
+	if ok {
+		return v, nil
+	}
+
+	e.sem <- struct{}{}
+	defer func() { <-e.sem }()
+
+	backoff := e.Backoff
+
+	var err error
+
+	for attempt := 0; attempt <= e.Retries; attempt++ {
+		if attempt > 0 {
+			ui.Log.Debug("Retrying lookup", ui.F("key", key), ui.F("attempt", attempt), ui.F("error", err))
+
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+
+			backoff *= 2
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, e.Timeout)
+		v, err = e.Resolver.Resolve(attemptCtx, key)
+		cancel()
+
+		if err == nil || errors.Is(err, ErrNotFound) {
+			break
+		}
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.cache[key] = v
+	e.unsaved++
+
+	if e.unsaved >= e.FlushEvery {
+		if err := e.saveCache(); err != nil {
+			ui.Log.Warn("Could not save enrichment cache", ui.F("path", e.CachePath), ui.F("error", err))
+		}
+	}
+
+	return v, nil
+}
+
+// Like Lookup, but returns Fallback if the lookup fails. Panics with the context error if ctx is done, so an
+// interrupted run does not migrate fallbacks
+func (e *Enricher) LookupOrFallback(ctx context.Context, key string) any {
+	res, err := e.Lookup(ctx, key)
+
+	if ctx.Err() != nil {
+		panic(ctx.Err())
+	}
+
+	if err != nil {
+		ui.Log.Warn("Lookup failed, using fallback", ui.F("key", key), ui.F("error", err))
+		return e.Fallback
+	}
+
+	return res
+}
+
+// Returns a transform replacing the value with the one looked up for the keyField of the record,
+// or Fallback if the lookup fails
+func (e *Enricher) Transform(keyField string) column.ContextTransform {
+	return func(tc *column.TransformContext, record map[string]any, v any) any {
+		key, ok := record[keyField]
+
+		if !ok || key == nil {
+			return e.Fallback
+		}
+
+		return e.LookupOrFallback(tc.Ctx, fmt.Sprint(key))
+	}
+}
+
+// Like Transform, but keeps values that are already set (non-nil and non-empty)
+func (e *Enricher) FillMissing(keyField string) column.ContextTransform {
+	lookup := e.Transform(keyField)
+
+	return func(tc *column.TransformContext, record map[string]any, v any) any {
+		if v != nil && v != "" {
+			return v
+		}
+
+		return lookup(tc, record, v)
+	}
+}
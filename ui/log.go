@@ -0,0 +1,238 @@
+package ui
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarning
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug:   "debug",
+	LevelInfo:    "info",
+	LevelWarning: "warning",
+	LevelError:   "error",
+}
+
+var levelColors = map[Level]func(a ...any) string{
+	LevelDebug:   debugFunc,
+	LevelInfo:    infoFunc,
+	LevelWarning: warningFunc,
+	LevelError:   errorFunc,
+}
+
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+
+	return "level(" + fmt.Sprint(int(l)) + ")"
+}
+
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func ParseLevel(s string) (Level, error) {
+	for level, name := range levelNames {
+		if name == s {
+			return level, nil
+		}
+	}
+
+	return 0, errors.New("invalid log level " + s)
+}
+
+// A key-value pair attached to a log entry, such as the table or iteration it is about
+type Field struct {
+	Key   string
+	Value any
+}
+
+// To make things more ergonomic
+func F(key string, value any) Field {
+	return Field{Key: key, Value: value}
+}
+
+type Entry struct {
+	Time   time.Time
+	Level  Level
+	Msg    string
+	Fields []Field
+}
+
+// Somewhere log entries are written to
+type Output interface {
+	Write(e Entry) error
+}
+
+// Writes entries as "level: msg key=value" to the Reporter, so they do not garble progress bars
+type ConsoleOutput struct {
+	Color bool
+}
+
+func (c ConsoleOutput) Write(e Entry) error {
+	level := e.Level.String()
+
+	if c.Color {
+		level = levelColors[e.Level](level)
+	}
+
+	var b strings.Builder
+
+	b.WriteString(level + ": " + e.Msg)
+
+	for _, f := range e.Fields {
+		b.WriteString(" " + f.Key + "=" + formatValue(f.Value))
+	}
+
+	b.WriteString("\n")
+
+	_, err := io.WriteString(Reporter.Writer(), b.String())
+	return err
+}
+
+func formatValue(v any) string {
+	s := fmt.Sprint(v)
+
+	if strings.ContainsAny(s, " \t\n\"=") {
+		return fmt.Sprintf("%q", s)
+	}
+
+	return s
+}
+
+// Writes entries as JSON lines
+type JSONOutput struct {
+	W io.Writer
+}
+
+// Opens (appending to) a JSON lines log file
+func NewJSONFileOutput(path string) (*JSONOutput, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &JSONOutput{W: f}, nil
+}
+
+func (j *JSONOutput) Write(e Entry) error {
+	line := map[string]any{
+		"time":  e.Time.Format(time.RFC3339Nano),
+		"level": e.Level.String(),
+		"msg":   e.Msg,
+	}
+
+	for _, f := range e.Fields {
+		// Errors marshal to {} otherwise
+		if err, ok := f.Value.(error); ok {
+			line[f.Key] = err.Error()
+		} else {
+			line[f.Key] = f.Value
+		}
+	}
+
+	bytes, err := json.Marshal(line)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = j.W.Write(append(bytes, '\n'))
+	return err
+}
+
+type Logger struct {
+	// Entries below this level are dropped
+	MinLevel Level
+	Outputs  []Output
+
+	mu     *sync.Mutex
+	fields []Field
+}
+
+func NewLogger(minLevel Level, outputs ...Output) *Logger {
+	return &Logger{
+		MinLevel: minLevel,
+		Outputs:  outputs,
+		mu:       &sync.Mutex{},
+	}
+}
+
+// Returns a logger adding the fields to every entry, sharing the outputs of l
+func (l *Logger) With(fields ...Field) *Logger {
+	child := *l
+	child.fields = append(append([]Field{}, l.fields...), fields...)
+	return &child
+}
+
+func (l *Logger) Log(level Level, msg string, fields ...Field) {
+	if level < l.MinLevel {
+		return
+	}
+
+	e := Entry{
+		Time:   time.Now(),
+		Level:  level,
+		Msg:    msg,
+		Fields: append(append([]Field{}, l.fields...), fields...),
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, out := range l.Outputs {
+		if err := out.Write(e); err != nil {
+			fmt.Fprintln(os.Stderr, "could not write log entry:", err)
+		}
+	}
+}
+
+func (l *Logger) Debug(msg string, fields ...Field) {
+	l.Log(LevelDebug, msg, fields...)
+}
+
+func (l *Logger) Info(msg string, fields ...Field) {
+	l.Log(LevelInfo, msg, fields...)
+}
+
+func (l *Logger) Warn(msg string, fields ...Field) {
+	l.Log(LevelWarning, msg, fields...)
+}
+
+func (l *Logger) Error(msg string, fields ...Field) {
+	l.Log(LevelError, msg, fields...)
+}
+
+// Colour is left out when stdout is not a terminal, NO_COLOR is set or we are running in CI
+func defaultColor() bool {
+	return !color.NoColor && os.Getenv("CI") == ""
+}
+
+func defaultLevel() Level {
+	if os.Getenv("DEBUG_SPAM") == "1" {
+		return LevelDebug
+	}
+
+	return LevelInfo
+}
+
+// The logger used by NotifyMsg and the rest of pouncecat, replace it to change where logs go
+var Log = NewLogger(defaultLevel(), ConsoleOutput{Color: defaultColor()})
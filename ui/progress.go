@@ -0,0 +1,270 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattn/go-isatty"
+	"github.com/vbauerster/mpb/v8"
+	"github.com/vbauerster/mpb/v8/decor"
+)
+
+// A single progress counter started by a Progress
+type ProgressBar interface {
+	Increment()
+	// Stops the bar before it completes, removing it from the display if drop is set
+	Abort(drop bool)
+	// Waits for the bar to be done rendering after it completed or was aborted
+	Wait()
+}
+
+// Displays the progress of a migration
+type Progress interface {
+	// Starts a new bar. If removeOld is set, the bars of the previous table are cleared first
+	StartBar(name string, count int64, removeOld bool) ProgressBar
+	// Where log lines should be written so they do not garble the progress display
+	Writer() io.Writer
+}
+
+// The progress display used by StartBar, replace it to change how progress is shown
+var Reporter Progress = NewProgress(ProgressAuto, 0)
+
+// To make things more ergonomic
+func StartBar(name string, count int64, removeOld bool) ProgressBar {
+	return Reporter.StartBar(name, count, removeOld)
+}
+
+type ProgressMode string
+
+const (
+	// Bars on a terminal, plain lines otherwise
+	ProgressAuto  ProgressMode = "auto"
+	ProgressBars  ProgressMode = "bars"
+	ProgressPlain ProgressMode = "plain"
+	ProgressNone  ProgressMode = "none"
+)
+
+func ParseProgressMode(s string) (ProgressMode, error) {
+	switch ProgressMode(s) {
+	case ProgressAuto, ProgressBars, ProgressPlain, ProgressNone:
+		return ProgressMode(s), nil
+	}
+
+	return "", errors.New("invalid progress mode " + s)
+}
+
+// Creates the progress display for the mode. interval is how often plain progress lines are printed, defaulting to 10 seconds
+func NewProgress(mode ProgressMode, interval time.Duration) Progress {
+	if mode == ProgressAuto {
+		if isTerminal() {
+			mode = ProgressBars
+		} else {
+			mode = ProgressPlain
+		}
+	}
+
+	switch mode {
+	case ProgressBars:
+		return &MpbProgress{}
+	case ProgressNone:
+		return SilentProgress{}
+	}
+
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	return &PlainProgress{Interval: interval, W: os.Stdout}
+}
+
+func isTerminal() bool {
+	if os.Getenv("CI") != "" {
+		return false
+	}
+
+	return isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd())
+}
+
+// Progress bars on a terminal
+type MpbProgress struct {
+	mb  *mpb.Progress
+	bar *mpb.Bar
+}
+
+func (p *MpbProgress) StartBar(schemaName string, count int64, removeOld bool) ProgressBar {
+	if p.bar != nil && removeOld {
+		p.bar.Abort(true)
+		p.bar.Wait()
+		p.mb.Wait()
+	}
+
+	if removeOld || p.mb == nil {
+		p.mb = mpb.New(mpb.WithWidth(64))
+	}
+
+	bar := p.mb.New(
+		count,
+		// BarFillerBuilder with custom style
+		mpb.BarStyle(),
+		mpb.PrependDecorators(
+			// display our name with one space on the right
+			decor.Name(schemaName, decor.WC{W: len(schemaName) + 1, C: decor.DidentRight}),
+			// replace ETA decorator with "done" message, OnComplete event
+			decor.OnComplete(
+				decor.AverageETA(decor.ET_STYLE_GO, decor.WC{W: 4}), "done",
+			),
+		),
+		mpb.AppendDecorators(
+			// Percentage decorator with width reservation and no extra space
+			decor.Percentage(),
+			// Set a counter at the end of the bar
+			decor.Counters(0, " [%d/%d]", decor.WC{W: len(schemaName) + 1, C: decor.DidentRight}),
+		),
+		mpb.BarRemoveOnComplete(),
+	)
+
+	bar.SetCurrent(0)
+
+	if removeOld {
+		p.bar = bar
+	}
+
+	return bar
+}
+
+func (p *MpbProgress) Writer() io.Writer {
+	if p.mb == nil {
+		return os.Stdout
+	}
+
+	// Send message to daemon
+	return p.mb
+}
+
+// Periodic plain-text progress lines, for logs and CI where bars turn into garbage
+type PlainProgress struct {
+	Interval time.Duration
+	W        io.Writer
+
+	mu sync.Mutex
+}
+
+func (p *PlainProgress) StartBar(name string, count int64, removeOld bool) ProgressBar {
+	bar := &plainBar{
+		progress: p,
+		name:     name,
+		total:    count,
+		started:  time.Now(),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go bar.run()
+
+	return bar
+}
+
+func (p *PlainProgress) Writer() io.Writer {
+	return p.W
+}
+
+func (p *PlainProgress) println(line string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fmt.Fprintln(p.W, line)
+}
+
+type plainBar struct {
+	progress *PlainProgress
+	name     string
+	total    int64
+	current  atomic.Int64
+	started  time.Time
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func (b *plainBar) Increment() {
+	if b.current.Add(1) == b.total {
+		b.finish()
+	}
+}
+
+func (b *plainBar) Abort(drop bool) {
+	b.finish()
+}
+
+func (b *plainBar) Wait() {
+	<-b.done
+}
+
+func (b *plainBar) finish() {
+	b.stopOnce.Do(func() { close(b.stop) })
+}
+
+func (b *plainBar) run() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.progress.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.progress.println(b.line())
+		case <-b.stop:
+			current := b.current.Load()
+
+			// Nothing worth reporting for bars that never got going
+			if current > 0 {
+				b.progress.println(fmt.Sprintf("%s: done, %d/%d in %s", b.name, current, b.total, time.Since(b.started).Round(time.Millisecond)))
+			}
+
+			return
+		}
+	}
+}
+
+func (b *plainBar) line() string {
+	current := b.current.Load()
+	elapsed := time.Since(b.started)
+
+	line := fmt.Sprintf("%s: %d/%d", b.name, current, b.total)
+
+	if b.total > 0 {
+		line += fmt.Sprintf(" (%.1f%%)", float64(current)/float64(b.total)*100)
+	}
+
+	if current == 0 || elapsed <= 0 {
+		return line
+	}
+
+	rate := float64(current) / elapsed.Seconds()
+	eta := time.Duration(float64(b.total-current) / rate * float64(time.Second))
+
+	return line + fmt.Sprintf(", %.0f rows/s, ETA %s", rate, eta.Round(time.Second))
+}
+
+// Shows no progress at all
+type SilentProgress struct{}
+
+func (SilentProgress) StartBar(name string, count int64, removeOld bool) ProgressBar {
+	return silentBar{}
+}
+
+func (SilentProgress) Writer() io.Writer {
+	return os.Stdout
+}
+
+type silentBar struct{}
+
+func (silentBar) Increment()      {}
+func (silentBar) Abort(drop bool) {}
+func (silentBar) Wait()           {}